@@ -0,0 +1,87 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+func TestPaddingDirectives(t *testing.T) {
+	tests := []struct {
+		source string
+		flash  []byte
+	}{
+		// .align counts words
+		{source: "ldi r16, 1\n.align 2\nldi r16, 1\n", flash: []byte{0x01, 0xe0, 0, 0, 0x01, 0xe0}},
+		{source: "ldi r16, 1\n.align 2, 0xff\nldi r16, 1\n", flash: []byte{0x01, 0xe0, 0xff, 0xff, 0x01, 0xe0}},
+		{source: ".align 4\nldi r16, 1\n", flash: []byte{0x01, 0xe0}},
+		// .balign counts bytes
+		{source: "ldi r16, 1\n.balign 8, 0xaa\nldi r16, 1\n", flash: []byte{0x01, 0xe0, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0x01, 0xe0}},
+		{source: "ldi r16, 1\n.balign 2\nldi r16, 1\n", flash: []byte{0x01, 0xe0, 0x01, 0xe0}},
+		// Odd sized flash data is filled up to a whole word
+		{source: ".fill 3, 1, 0x5a\nldi r16, 1\n", flash: []byte{0x5a, 0x5a, 0x5a, 0x5a, 0x01, 0xe0}},
+		{source: ".fill 2, 2, 0x1234\n", flash: []byte{0x34, 0x12, 0x34, 0x12}},
+		{source: ".fill 1, 4, 0x1234\n", flash: []byte{0x34, 0x12, 0, 0}},
+		{source: ".space 3, 0xcc\nldi r16, 1\n", flash: []byte{0xcc, 0xcc, 0xcc, 0xcc, 0x01, 0xe0}},
+		{source: ".space 2\nldi r16, 1\n", flash: []byte{0, 0, 0x01, 0xe0}},
+	}
+	for _, tt := range tests {
+		program := mustAssemble(t, avrassembler.Options{}, tt.source)
+		if got := program.Flash.Read(0, uint32(program.Flash.Len())); !bytes.Equal(got, tt.flash) {
+			t.Errorf("%q placed % x, want % x", tt.source, got, tt.flash)
+		}
+	}
+}
+
+func TestPaddingErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		code   string
+	}{
+		{source: ".align 3\n", code: avrassembler.CodeAlignment},
+		{source: ".balign 0\n", code: avrassembler.CodeAlignment},
+		{source: ".balign 6\n", code: avrassembler.CodeAlignment},
+		{source: ".fill 1, 3\n", code: avrassembler.CodeValueRange},
+		{source: ".align 2, 0x100\n", code: avrassembler.CodeValueRange},
+		{source: ".space 2, 0x100\n", code: avrassembler.CodeValueRange},
+		// Padding over code placed before
+		{source: ".org 4\nldi r16, 1\n.org 0\n.space 6\n", code: avrassembler.CodeOverlap},
+		{source: ".org 4\nldi r16, 1\n.org 0\nnop\n.balign 8, 0xff\n", code: avrassembler.CodeOverlap},
+	}
+	for _, tt := range tests {
+		_, _, err := assemble(t, avrassembler.Options{}, fstest.MapFS{"main.S": {Data: []byte(tt.source)}})
+		if codes := errorCodes(err); !slices.Equal(codes, []string{tt.code}) {
+			t.Errorf("%q failed with %v, want %s", tt.source, codes, tt.code)
+		}
+	}
+}
+
+func TestSpaceOutsideFlash(t *testing.T) {
+	program := mustAssemble(t, avrassembler.Options{Device: "atmega328p"}, `.dseg
+.space 5
+buf: .byte 1
+.eseg
+.space 2, 0x11
+table: .db "A"
+`)
+	if program.DataStart != 0x100 || program.DataEnd != 0x106 {
+		t.Errorf("SRAM spans 0x%x to 0x%x, want 0x100 to 0x106", program.DataStart, program.DataEnd)
+	}
+	addresses := map[string]uint32{}
+	for _, s := range program.Symbols {
+		addresses[s.Name] = s.Value
+	}
+	if addresses["buf"] != 0x105 || addresses["table"] != 2 {
+		t.Errorf("buf is at 0x%x and table at 0x%x, want 0x105 and 0x2", addresses["buf"], addresses["table"])
+	}
+	want := []byte{0x11, 0x11, 'A', 0}
+	if got := program.EEPROM.Read(0, uint32(program.EEPROM.Len())); !bytes.Equal(got, want) {
+		t.Errorf("EEPROM holds % x, want % x", got, want)
+	}
+	if program.Flash.Len() != 0 {
+		t.Errorf("flash holds %d bytes, want none", program.Flash.Len())
+	}
+}
@@ -0,0 +1,269 @@
+package avrassembler
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Assembler holds everything about one program, from the sources added to it
+// to the encoded memory images. Separate Assemblers share no state and can run
+// concurrently
+type Assembler struct {
+	// Device selected with Options.Device or .device, nil when none was given
+	TargetDevice *Device
+
+	// Directories searched by .include and .import after the including file's directory
+	IncludePaths []string
+
+	// Root directory holding device definition files such as m328Pdef.inc
+	IncludeRoot string
+
+	// Filesystem sources, includes and .incbin files are read from
+	FS fs.FS
+
+	// Errors reported before the run stops, 0 to report every error
+	MaxErrors int
+
+	// Warning categories switched on or off, the others keep their default, see WarningCategories
+	Warnings map[string]bool
+
+	// Report every warning as an error
+	WarningsAsErrors bool
+
+	// Data bytes per Intel HEX or S-record line, 16 and 32 are the common choices
+	HexRecordLength int
+
+	// Label or byte address written as the start linear address (type 05) record, empty to omit
+	EntryPoint string
+
+	// Output file format, hex, srec, bin or elf
+	OutputFormat string
+
+	// First address written to binary output
+	BinaryBase uint32
+
+	// Pad binary output to the flash size of TargetDevice
+	PadToFlash bool
+
+	// Listing file written alongside the output, empty to skip
+	ListingFile string
+
+	// Map file with section placement and memory usage, empty to skip
+	MapFile string
+
+	// Intel HEX file for EEPROM contents, empty to use the output name with .eep
+	EEPROMFile string
+
+	// Instruction Sections
+	RawAssemblySections []AssemblySection
+	RawMacroSections    map[string][]Instruction
+
+	// Labels in Memory (word addresses). Labels local to a file are keyed
+	// file:name and .global ones by their name
+	LabelMap map[string]uint32
+
+	// Labels in the data and EEPROM segments, keyed like LabelMap
+	DataLabelMap map[string]DataLabel
+
+	// Segment being assembled, switched with .cseg, .dseg and .eseg
+	CurrentSegment Segment
+
+	// Byte location counters of the data and EEPROM segments
+	SegmentLocation map[Segment]uint32
+
+	// Data blobs (strings for now) in memory
+	DbSections []DataBlob
+
+	// Constants set with .define and .equ, narrowed to 16 bits where an operand uses them
+	VariableMapping map[string]int64
+
+	// Files each source file pulled in with .import or .include
+	ImportGraph map[string][]string
+
+	// Files marked with .once that have already been assembled
+	OnceFiles map[string]bool
+
+	// Every source file in the order it was first entered, the main file first
+	SourceFiles []string
+
+	// Where every label and constant was defined, used for the listing cross-reference
+	SymbolDefinitions map[string]SourceLocation
+
+	// Every source line and macro expansion for the listing file
+	ListingLines []ListingLine
+
+	// Assertions checked once all labels are resolved
+	Assertions []Assertion
+
+	// Fuse and lock values from .fuse and .lock, keyed by FuseByte name
+	FuseValues map[string]byte
+
+	// Errors, warnings and messages reported so far
+	diagnostics []Diagnostic
+
+	// Where each fuse was first set, for the warning when it changes
+	fuseSources map[string]SourceLocation
+
+	// .pragma warning lines in the order they were read
+	warningPragmas []warningPragma
+
+	// Labels and constants looked up by an instruction or expression
+	referencedSymbols map[string]bool
+
+	// File and enclosing label names are looked up from
+	scope labelScope
+
+	// Labels named by .global, keyed file:name
+	exports map[string]bool
+
+	// .extern lines checked once every file is parsed
+	externs []externDeclaration
+
+	// Where each label name is written, keyed like SymbolDefinitions
+	labelSpans map[string]Span
+
+	// Files currently being parsed, outermost first
+	importStack []importFrame
+
+	// .include line that first pulled in each file, keyed by absolute path
+	includeSites map[string]SourceLocation
+
+	// Sources queued by AddFile and AddSource
+	sources []source
+
+	// Set once Assemble succeeds
+	program *Program
+
+	// Set once Assemble has run, whether or not it succeeded
+	assembled bool
+}
+
+// Settings applied before the first source line is read
+type Options struct {
+	Device       string           // Target device, for example atmega328p
+	IncludePaths []string         // Directories searched for .include files
+	IncludeRoot  string           // Directory holding device definition files
+	Defines      map[string]int64 // Constants set as if by .equ
+	FS           fs.FS            // Source files such as an embed.FS, nil reads from the OS filesystem
+	MaxErrors    int              // Stop after this many errors, 0 for no limit
+	Warnings     []string         // Warning flags as given to -W, such as unused-label, no-shadow, all or error
+}
+
+// Source queued for assembly, Reader is nil for files read from disk
+type source struct {
+	Name   string
+	Reader io.Reader
+}
+
+func NewAssembler(options Options) (*Assembler, error) {
+	a := &Assembler{
+		IncludePaths:      options.IncludePaths,
+		IncludeRoot:       options.IncludeRoot,
+		FS:                options.FS,
+		MaxErrors:         options.MaxErrors,
+		HexRecordLength:   16,
+		OutputFormat:      "hex",
+		RawMacroSections:  map[string][]Instruction{},
+		LabelMap:          map[string]uint32{},
+		DataLabelMap:      map[string]DataLabel{},
+		CurrentSegment:    CodeSegment,
+		SegmentLocation:   map[Segment]uint32{},
+		VariableMapping:   map[string]int64{},
+		ImportGraph:       map[string][]string{},
+		OnceFiles:         map[string]bool{},
+		SymbolDefinitions: map[string]SourceLocation{},
+		labelSpans:        map[string]Span{},
+		FuseValues:        map[string]byte{},
+		fuseSources:       map[string]SourceLocation{},
+		Warnings:          map[string]bool{},
+		referencedSymbols: map[string]bool{},
+		exports:           map[string]bool{},
+		includeSites:      map[string]SourceLocation{},
+	}
+	if a.FS == nil {
+		a.FS = osFS{}
+	}
+	if options.Device != "" {
+		err := a.SetDevice(options.Device)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, flag := range options.Warnings {
+		err := a.SetWarning(flag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range options.Defines {
+		a.VariableMapping[name] = value
+	}
+	return a, nil
+}
+
+// Queue a source file, files are assembled one after another in the order added
+func (a *Assembler) AddFile(fn string) {
+	a.sources = append(a.sources, source{Name: fn})
+}
+
+// Queue source text, name is used in messages and to resolve relative includes
+func (a *Assembler) AddSource(name string, r io.Reader) {
+	a.sources = append(a.sources, source{Name: name, Reader: r})
+}
+
+// Parse every queued source, resolve labels and encode flash and EEPROM. Errors
+// on one line don't stop the run, every error found is returned as an ErrorList
+func (a *Assembler) Assemble() (*Program, error) {
+	// Sources are read and state is filled in once, a second run would repeat both
+	if a.assembled {
+		return nil, fmt.Errorf("program was already assembled, use a new Assembler for another program")
+	}
+	if len(a.sources) == 0 {
+		return nil, fmt.Errorf("no sources to assemble, add one with AddFile or AddSource")
+	}
+	a.assembled = true
+	address := uint32(0)
+	for _, source := range a.sources {
+		var err error
+		if source.Reader != nil {
+			address, err = a.parseSource(source.Name, source.Reader, address)
+		} else {
+			address, err = a.ParseFile(source.Name, address)
+		}
+		if err != nil {
+			return nil, a.fail(source.Name, err)
+		}
+	}
+	err := a.checkExterns()
+	if err != nil {
+		return nil, a.fail("", err)
+	}
+	err = a.CheckAssertions()
+	if err != nil {
+		return nil, a.fail("", err)
+	}
+	flash, instructions, err := a.encodeFlash()
+	if err != nil {
+		return nil, a.fail("", err)
+	}
+	a.checkUnusedLabels()
+	eeprom, err := a.encodeEEPROM()
+	if err != nil {
+		return nil, a.fail("", err)
+	}
+	err = a.checkEEPROMSize(eeprom)
+	if err != nil {
+		return nil, a.fail("", err)
+	}
+	if len(a.errors()) > 0 {
+		return nil, a.errors()
+	}
+	a.program = a.buildProgram(flash, instructions, eeprom)
+	return a.program, nil
+}
+
+// Assembled program, nil until Assemble succeeds
+func (a *Assembler) Result() *Program {
+	return a.program
+}
@@ -0,0 +1,72 @@
+package avrassembler_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+// Assemble main.S from files. program is nil and err the ErrorList when
+// assembly fails, the Assembler is returned for its diagnostics
+func assemble(t *testing.T, options avrassembler.Options, files fstest.MapFS) (*avrassembler.Assembler, *avrassembler.Program, error) {
+	t.Helper()
+	options.FS = files
+	asm, err := avrassembler.NewAssembler(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm.AddFile("main.S")
+	program, err := asm.Assemble()
+	return asm, program, err
+}
+
+// Assemble a single main.S that must assemble without errors
+func mustAssemble(t *testing.T, options avrassembler.Options, source string) *avrassembler.Program {
+	t.Helper()
+	_, program, err := assemble(t, options, fstest.MapFS{"main.S": {Data: []byte(source)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// Codes of the diagnostics in an ErrorList, in the order they were reported
+func errorCodes(err error) []string {
+	codes := []string{}
+	var list avrassembler.ErrorList
+	if errors.As(err, &list) {
+		for _, d := range list {
+			codes = append(codes, d.Code)
+		}
+	}
+	return codes
+}
+
+func TestAssembleRunsOnce(t *testing.T) {
+	for _, source := range []string{"nop\n", "frob r1\n"} {
+		asm, err := avrassembler.NewAssembler(avrassembler.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		asm.AddSource("main.S", strings.NewReader(source))
+		asm.Assemble()
+		_, err = asm.Assemble()
+		var list avrassembler.ErrorList
+		if err == nil || errors.As(err, &list) {
+			t.Errorf("second Assemble of %q returned %v, want an already assembled error", source, err)
+		}
+	}
+}
+
+func TestDefineWithoutName(t *testing.T) {
+	for _, source := range []string{".equ = 5\n", ".define = 5\n", ".equ =5\n"} {
+		_, _, err := assemble(t, avrassembler.Options{}, fstest.MapFS{"main.S": {Data: []byte(source)}})
+		if codes := errorCodes(err); !slices.Equal(codes, []string{avrassembler.CodeSyntax}) {
+			t.Errorf("%q failed with %v, want %s", source, codes, avrassembler.CodeSyntax)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+// Output settings of a binary file
+type binarySettings struct {
+	device string
+	base   uint32
+	pad    bool
+}
+
+// Assemble source and write it as a binary file, returning its contents
+func writeBinary(t *testing.T, settings binarySettings, source string) ([]byte, error) {
+	t.Helper()
+	asm, _, err := assemble(t, avrassembler.Options{Device: settings.device}, fstest.MapFS{"main.S": {Data: []byte(source)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm.OutputFormat, asm.BinaryBase, asm.PadToFlash = "bin", settings.base, settings.pad
+	fn := filepath.Join(t.TempDir(), "main.bin")
+	err = asm.WriteToFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fn)
+}
+
+func TestBinaryOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings binarySettings
+		source   string
+		want     []byte
+	}{
+		{name: "gaps are erased flash", source: "ldi r16, 1\n.org 6\nldi r16, 1\n", want: []byte{0x01, 0xe0, 0xff, 0xff, 0xff, 0xff, 0x01, 0xe0}},
+		{name: "leading gap", source: ".org 4\nldi r16, 1\n", want: []byte{0xff, 0xff, 0xff, 0xff, 0x01, 0xe0}},
+		{name: "base skips the leading gap", settings: binarySettings{base: 4}, source: ".org 4\nldi r16, 1\n", want: []byte{0x01, 0xe0}},
+		{name: "base inside the gap", settings: binarySettings{base: 2}, source: ".org 4\nldi r16, 1\n", want: []byte{0xff, 0xff, 0x01, 0xe0}},
+	}
+	for _, tt := range tests {
+		got, err := writeBinary(t, tt.settings, tt.source)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: wrote % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryPadToFlash(t *testing.T) {
+	got, err := writeBinary(t, binarySettings{device: "attiny85", pad: true}, "ldi r16, 1\n.org 8\nldi r16, 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte{0xff}, 8*1024)
+	copy(want, []byte{0x01, 0xe0})
+	copy(want[8:], []byte{0x01, 0xe0})
+	if !bytes.Equal(got, want) {
+		t.Errorf("wrote %d bytes starting % x, want %d bytes starting % x", len(got), got[:min(len(got), 10)], len(want), want[:10])
+	}
+
+	// The base is cut off the padded size too
+	got, err = writeBinary(t, binarySettings{device: "attiny85", base: 8, pad: true}, ".org 8\nldi r16, 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 8*1024-8 || !bytes.Equal(got[:2], []byte{0x01, 0xe0}) {
+		t.Errorf("wrote %d bytes starting % x, want %d starting 01 e0", len(got), got[:min(len(got), 2)], 8*1024-8)
+	}
+}
+
+func TestBinaryOutputErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings binarySettings
+		source   string
+	}{
+		{name: "data below the base", settings: binarySettings{base: 4}, source: "ldi r16, 1\n.org 4\nldi r16, 1\n"},
+		{name: "padding without a device", settings: binarySettings{pad: true}, source: "ldi r16, 1\n"},
+	}
+	for _, tt := range tests {
+		if _, err := writeBinary(t, tt.settings, tt.source); err == nil {
+			t.Errorf("%s: wrote the binary, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package avrassembler
+
+// Stable codes identifying each kind of diagnostic. Messages may be reworded
+// between releases, tools annotating source should match on the code
+const (
+	// Error that has no more specific code
+	CodeError = "E0000"
+	// Line or directive that could not be parsed
+	CodeSyntax = "E0001"
+	// Statement that is not allowed where it appears, such as a label inside a macro
+	CodeMisplaced = "E0002"
+
+	// Operand is not a register, or not one the instruction accepts
+	CodeRegisterRange = "E0101"
+	// Immediate, I/O address, bit number or fill value does not fit its field
+	CodeValueRange = "E0102"
+	// Relative branch or jump target is too far away
+	CodeBranchRange = "E0103"
+	// Label or constant that was never defined
+	CodeUndefinedSymbol = "E0104"
+	// Mnemonic the assembler does not know
+	CodeUnknownInstruction = "E0105"
+	// Label defined more than once
+	CodeDuplicateSymbol = "E0106"
+
+	// Source, include or .incbin file that could not be found or read
+	CodeFileNotFound = "E0201"
+	// File that includes itself through a chain of includes
+	CodeCircularInclude = "E0202"
+	// .if or .macro without its .endif or .endmacro
+	CodeUnterminatedBlock = "E0203"
+	// Address or alignment that is not usable
+	CodeAlignment = "E0204"
+	// .error directive in the source
+	CodeErrorDirective = "E0205"
+	// .assert whose condition is false
+	CodeAssertion = "E0206"
+	// Unknown or conflicting target device
+	CodeDevice = "E0207"
+	// Fuse or lock value that is not valid for the device
+	CodeFuse = "E0208"
+	// Contents that do not fit the memory of the device
+	CodeMemoryOverflow = "E0209"
+	// Code or data placed where earlier code or data already is
+	CodeOverlap = "E0210"
+
+	// .warning directive in the source
+	CodeWarningDirective = "W0001"
+	// Constant given a different value by another .define or .equ
+	CodeRedefined = "W0002"
+	// Label nothing refers to
+	CodeUnusedLabel = "W0003"
+	// Label named like a register, instruction or constant
+	CodeShadowedName = "W0004"
+	// Odd number of .db bytes in flash, padded with a zero byte
+	CodeOddData = "W0005"
+	// Instruction after an unconditional jump or return with no label before it
+	CodeUnreachable = "W0006"
+	// .pragma the assembler does not know
+	CodeUnknownPragma = "W0007"
+	// Fuse or lock setting that leaves the device hard to reprogram
+	CodeFuseHazard = "W0101"
+	// Fuse or lock byte set again with a different value
+	CodeFuseChanged = "W0102"
+	// File included more than once without .once
+	CodeRepeatedInclude = "W0201"
+
+	// .message directive in the source
+	CodeMessageDirective = "I0001"
+)
+
+// Short description of every code, used for SARIF rules
+var codeTitles = map[string]string{
+	CodeError:              "error",
+	CodeSyntax:             "syntax error",
+	CodeMisplaced:          "statement not allowed here",
+	CodeRegisterRange:      "register out of range",
+	CodeValueRange:         "value out of range",
+	CodeBranchRange:        "branch target out of range",
+	CodeUndefinedSymbol:    "undefined symbol",
+	CodeUnknownInstruction: "unknown instruction",
+	CodeDuplicateSymbol:    "duplicate label",
+	CodeFileNotFound:       "file not found",
+	CodeCircularInclude:    "circular include",
+	CodeUnterminatedBlock:  "unterminated block",
+	CodeAlignment:          "misaligned address",
+	CodeErrorDirective:     ".error directive",
+	CodeAssertion:          "assertion failed",
+	CodeDevice:             "device error",
+	CodeFuse:               "invalid fuse setting",
+	CodeMemoryOverflow:     "memory overflow",
+	CodeOverlap:            "overlapping code or data",
+	CodeWarningDirective:   ".warning directive",
+	CodeRedefined:          "constant redefined",
+	CodeUnusedLabel:        "unused label",
+	CodeShadowedName:       "label shadows a register, instruction or constant",
+	CodeOddData:            "odd length data padded",
+	CodeUnreachable:        "unreachable code",
+	CodeUnknownPragma:      "unknown pragma",
+	CodeFuseHazard:         "fuse setting locks out the programmer",
+	CodeFuseChanged:        "fuse value changed",
+	CodeRepeatedInclude:    "file included more than once",
+	CodeMessageDirective:   ".message directive",
+}
+
+// Short description of a diagnostic code such as E0101
+func CodeTitle(code string) string {
+	return codeTitles[code]
+}
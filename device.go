@@ -0,0 +1,53 @@
+package avrassembler
+
+import (
+	"slices"
+	"strings"
+)
+
+// Memory layout of a supported microcontroller, sizes in bytes
+type Device struct {
+	Name         string
+	FlashSize    uint32
+	SRAMStart    uint32 // First data address after the register and I/O space
+	SRAMSize     uint32
+	EEPROMSize   uint32
+	Architecture uint32 // avr-gcc architecture number, stored in the ELF header flags
+	Signature    [3]byte
+	Fuses        []FuseByte // Low byte first
+	Lock         FuseByte
+	IORegisters  map[string]uint16 // Addresses by name, see ioregisters.go
+}
+
+// Supported devices keyed by lower case name
+var Devices = map[string]Device{
+	"atmega8515": {Name: "ATmega8515", FlashSize: 8 * 1024, SRAMStart: 0x60, SRAMSize: 512, EEPROMSize: 512, Architecture: 4,
+		Signature: [3]byte{0x1e, 0x93, 0x06}, Fuses: atmega8515Fuses, Lock: bootLockBits,
+		IORegisters: atmega8515IO},
+	"atmega328p": {Name: "ATmega328P", FlashSize: 32 * 1024, SRAMStart: 0x100, SRAMSize: 2048, EEPROMSize: 1024, Architecture: 5,
+		Signature: [3]byte{0x1e, 0x95, 0x0f}, Fuses: atmega328pFuses, Lock: bootLockBits,
+		IORegisters: atmega328pIO},
+	"atmega2560": {Name: "ATmega2560", FlashSize: 256 * 1024, SRAMStart: 0x200, SRAMSize: 8192, EEPROMSize: 4096, Architecture: 6,
+		Signature: [3]byte{0x1e, 0x98, 0x01}, Fuses: atmega2560Fuses, Lock: bootLockBits,
+		IORegisters: atmega2560IO},
+	"attiny85": {Name: "ATtiny85", FlashSize: 8 * 1024, SRAMStart: 0x60, SRAMSize: 512, EEPROMSize: 512, Architecture: 25,
+		Signature: [3]byte{0x1e, 0x93, 0x0b}, Fuses: attiny85Fuses, Lock: attiny85Lock,
+		IORegisters: attiny85IO},
+}
+
+func (a *Assembler) SetDevice(name string) error {
+	device, ok := Devices[strings.ToLower(name)]
+	if !ok {
+		names := []string{}
+		for _, d := range Devices {
+			names = append(names, d.Name)
+		}
+		slices.Sort(names)
+		return codeError(CodeDevice, "unknown device %s, supported devices are %s", name, strings.Join(names, ", "))
+	}
+	if a.TargetDevice != nil && a.TargetDevice.Name != device.Name {
+		return codeError(CodeDevice, "device %s conflicts with previously selected %s", device.Name, a.TargetDevice.Name)
+	}
+	a.TargetDevice = &device
+	return nil
+}
@@ -0,0 +1,298 @@
+package avrassembler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	simplelog "github.com/ReidRise/simplelogger"
+)
+
+// How serious a diagnostic is
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+var severityNames = []string{"error", "warning", "message"}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+// Message about the source reported while assembling
+type Diagnostic struct {
+	Severity   Severity
+	Code       string // Stable code such as E0101, see the Code constants
+	Category   string // Warning category such as unused-label, also kept when -Werror made it an error
+	Message    string
+	Source     SourceLocation // Line is 0 for problems with a whole file or program
+	Column     int            // First column of the span starting at 1, 0 when unknown
+	EndColumn  int            // Column after the last one of the span
+	Text       string         // Source line the span points into
+	Related    []RelatedLocation
+	Suggestion string // Closest known name to replace a misspelled one with, empty when there is none
+	Err        error  // Error the diagnostic was made from, nil for warnings and messages
+}
+
+// Other source location a diagnostic refers to, such as an earlier definition.
+// Notes like did you mean suggestions have no Source
+type RelatedLocation struct {
+	Message string
+	Source  SourceLocation
+}
+
+// One line summary such as main.S:12:5: error: ...
+func (d Diagnostic) String() string {
+	location := d.Source.File
+	if d.Source.Line > 0 {
+		location += fmt.Sprintf(":%d", d.Source.Line)
+		if d.Column > 0 {
+			location += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	if location == "" {
+		location = fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+	} else {
+		location = fmt.Sprintf("%s: %s[%s]: %s", location, d.Severity, d.Code, d.Message)
+	}
+	if d.Category != "" {
+		location += fmt.Sprintf(" [-W%s]", d.Category)
+	}
+	return location
+}
+
+// Summary followed by the source line, a caret under the span and notes for
+// the related locations
+func (d Diagnostic) Render() string {
+	rendered := d.String()
+	if d.Source.Line > 0 && d.Text != "" {
+		gutter := fmt.Sprintf("%5d | ", d.Source.Line)
+		rendered += "\n" + gutter + d.Text
+		if d.Column > 0 && d.Column <= len(d.Text)+1 {
+			// Keep tabs so the caret lines up however the terminal expands them
+			indent := []byte{}
+			for _, c := range []byte(d.Text[:d.Column-1]) {
+				if c == '\t' {
+					indent = append(indent, '\t')
+				} else {
+					indent = append(indent, ' ')
+				}
+			}
+			width := max(d.EndColumn-d.Column, 1)
+			rendered += "\n" + strings.Repeat(" ", len(gutter)-2) + "| " + string(indent) + strings.Repeat("^", width)
+		}
+	}
+	for _, related := range d.Related {
+		if related.Source.File == "" {
+			rendered += fmt.Sprintf("\nnote: %s", related.Message)
+			continue
+		}
+		rendered += fmt.Sprintf("\n%s:%d: note: %s", related.Source.File, related.Source.Line, related.Message)
+	}
+	return rendered
+}
+
+// Every error of a failed run, returned by Assemble
+type ErrorList []Diagnostic
+
+func (e ErrorList) Error() string {
+	lines := []string{}
+	for _, d := range e {
+		lines = append(lines, d.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Errors behind the diagnostics, so errors.As finds a *RangeError and friends
+func (e ErrorList) Unwrap() []error {
+	errs := []error{}
+	for _, d := range e {
+		if d.Err != nil {
+			errs = append(errs, d.Err)
+		}
+	}
+	return errs
+}
+
+// Returned once MaxErrors errors were reported
+var errTooManyErrors = errors.New("too many errors")
+
+// Error with a diagnostic code or pointing at part of a source line, the
+// outermost code and span in a chain of wrapped errors win
+type sourceError struct {
+	Code      string
+	Column    int
+	EndColumn int
+	Err       error
+}
+
+func (e *sourceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.Err
+}
+
+// Error with a diagnostic code
+func codeError(code string, format string, args ...any) error {
+	return &sourceError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Implemented by the exported error types
+type codedError interface {
+	DiagnosticCode() string
+}
+
+type locatedError interface {
+	position() Span
+	locate(Span)
+}
+
+// Errors that point at other source locations as well
+type relatedError interface {
+	relatedLocations() []RelatedLocation
+}
+
+// Errors with a did you mean suggestion
+type suggestedError interface {
+	suggestion() string
+}
+
+// Diagnostic code of err, CodeError when it has none
+func errorCode(err error) string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*sourceError); ok && e.Code != "" {
+			return e.Code
+		}
+		if e, ok := err.(codedError); ok {
+			return e.DiagnosticCode()
+		}
+	}
+	return CodeError
+}
+
+// Columns err points at, 0 when it has none
+func errorSpan(err error) (column int, endColumn int) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*sourceError); ok && e.Column > 0 {
+			return e.Column, e.EndColumn
+		}
+		if e, ok := err.(locatedError); ok && e.position().Column > 0 {
+			return e.position().Column, e.position().EndColumn
+		}
+	}
+	return 0, 0
+}
+
+// Text of a line already read, for the snippet under a diagnostic
+func (a *Assembler) sourceText(fn string, line int) string {
+	for _, listing := range a.ListingLines {
+		if listing.File == fn && listing.Line == line {
+			return listing.Text
+		}
+	}
+	return ""
+}
+
+// Log a diagnostic about a whole line and keep it for the Program. Warnings
+// switched off are dropped and with WarningsAsErrors the rest become errors
+func (a *Assembler) report(severity Severity, code string, fn string, line int, msg string, related ...RelatedLocation) {
+	a.reportAt(severity, code, Span{File: fn, Line: line}, msg, related...)
+}
+
+// Like report, pointing at the columns of at when they are known
+func (a *Assembler) reportAt(severity Severity, code string, at Span, msg string, related ...RelatedLocation) {
+	d := Diagnostic{Severity: severity, Code: code, Message: msg, Source: SourceLocation{File: at.File, Line: at.Line},
+		Column: at.Column, EndColumn: at.EndColumn, Related: related}
+	if category := warningCategory(code); category != nil && severity == SeverityWarning {
+		if !a.warningEnabled(category, at.File, at.Line) {
+			return
+		}
+		d.Category = category.Name
+		if a.WarningsAsErrors {
+			d.Severity = SeverityError
+		}
+	}
+	a.emit(d)
+}
+
+// Log a diagnostic and keep it for the Program
+func (a *Assembler) emit(d Diagnostic) {
+	d.Message = strings.TrimSpace(d.Message)
+	d.Text = a.sourceText(d.Source.File, d.Source.Line)
+	a.diagnostics = append(a.diagnostics, d)
+	switch d.Severity {
+	case SeverityError:
+		simplelog.Error(d.Render())
+	case SeverityWarning:
+		simplelog.Warn(d.Render())
+	default:
+		simplelog.Info(d.Render())
+	}
+}
+
+// Record a recoverable error and keep going, the returned error is only set
+// once MaxErrors errors were reported and the run should stop
+func (a *Assembler) reportError(fn string, line int, err error) error {
+	if errors.Is(err, errTooManyErrors) {
+		return err
+	}
+	column, endColumn := errorSpan(err)
+	related, suggestion := []RelatedLocation{}, ""
+	// Typed errors are made without knowing the line, give them the place
+	// they are reported at
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if located, ok := e.(locatedError); ok {
+			located.locate(Span{File: fn, Line: line, Column: column, EndColumn: endColumn})
+		}
+		if e, ok := e.(relatedError); ok {
+			related = append(related, e.relatedLocations()...)
+		}
+		if e, ok := e.(suggestedError); ok && suggestion == "" {
+			suggestion = e.suggestion()
+		}
+	}
+	a.emit(Diagnostic{
+		Severity:   SeverityError,
+		Code:       errorCode(err),
+		Message:    err.Error(),
+		Source:     SourceLocation{File: fn, Line: line},
+		Column:     column,
+		EndColumn:  endColumn,
+		Related:    related,
+		Suggestion: suggestion,
+		Err:        err,
+	})
+	if a.MaxErrors > 0 && len(a.errors()) >= a.MaxErrors {
+		return fmt.Errorf("%w, stopping after %d", errTooManyErrors, a.MaxErrors)
+	}
+	return nil
+}
+
+// Every error, warning and message reported so far, also when Assemble failed
+func (a *Assembler) Diagnostics() []Diagnostic {
+	return slices.Clone(a.diagnostics)
+}
+
+// Errors reported so far
+func (a *Assembler) errors() ErrorList {
+	list := ErrorList{}
+	for _, d := range a.diagnostics {
+		if d.Severity == SeverityError {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// Stop the run on err, reporting it first unless it is the error limit
+func (a *Assembler) fail(fn string, err error) ErrorList {
+	a.reportError(fn, 0, err)
+	return a.errors()
+}
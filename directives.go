@@ -0,0 +1,278 @@
+package avrassembler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Directives whose arguments are kept as a raw expression instead of tokens
+var ExpressionDirectives = []string{
+	".if", ".elif", ".ifdef", ".ifndef",
+	".error", ".warning", ".message", ".assert",
+	".fuse", ".lock", ".define", ".equ",
+}
+
+// Directives handled by handleConditional, before the line is tokenized
+var ConditionalDirectives = []string{".if", ".ifdef", ".ifndef", ".elif", ".else", ".endif"}
+
+// Condition deferred until every label is known
+type Assertion struct {
+	Expression string
+	Message    string
+	File       string
+	Line       int
+
+	// Where labels in the expression are looked up
+	scope labelScope
+}
+
+// One level of .if/.elif/.else/.endif nesting
+type conditionalBlock struct {
+	active   bool // Current branch is being assembled
+	taken    bool // Some branch of this block was already assembled
+	inElse   bool // .else seen, no more branches allowed
+	enclosed bool // Enclosing block is being assembled
+}
+
+// Remove a trailing ; comment, ignoring semicolons inside quotes
+func stripComment(code string) string {
+	inQuote := false
+	for i := 0; i < len(code); i++ {
+		switch code[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '"':
+			inQuote = !inQuote
+		case ';':
+			if !inQuote {
+				return code[:i]
+			}
+		}
+	}
+	return code
+}
+
+// Split directive arguments on commas outside of quotes and parentheses
+func splitArguments(args string) []string {
+	parts := []string{}
+	depth, inQuote, start := 0, false, 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+		case '"':
+			inQuote = !inQuote
+		case '(':
+			if !inQuote {
+				depth++
+			}
+		case ')':
+			if !inQuote {
+				depth--
+			}
+		case ',':
+			if !inQuote && depth == 0 {
+				parts = append(parts, strings.TrimSpace(args[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(args[start:]))
+}
+
+// Message text for diagnostics directives, quotes are optional
+func parseMessage(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if len(msg) >= 2 && msg[0] == '"' && msg[len(msg)-1] == '"' {
+		unquoted, err := strconv.Unquote(msg)
+		if err == nil {
+			return unquoted
+		}
+		return msg[1 : len(msg)-1]
+	}
+	return msg
+}
+
+// Symbols visible to expressions, constants first and then labels, code
+// labels as word addresses and .dseg and .eseg labels as byte addresses
+func (a *Assembler) lookupExpressionSymbol(name string) (int64, error) {
+	if value, ok := a.VariableMapping[name]; ok {
+		return value, nil
+	}
+	if key := a.findLabel(name); key != "" {
+		a.referencedSymbols[key] = true
+		if addr, ok := a.LabelMap[key]; ok {
+			return int64(addr), nil
+		}
+		return int64(a.DataLabelMap[key].Address), nil
+	}
+	return 0, &UndefinedSymbolError{Symbol: name, Kind: "symbol", Suggestion: a.suggestSymbol(name, "symbol"), LocalTo: a.hiddenLabel(name)}
+}
+
+func (a *Assembler) isSymbolDefined(name string) bool {
+	_, isVariable := a.VariableMapping[strings.TrimPrefix(name, "$")]
+	isLabel := a.findLabel(name) != ""
+	_, isMacro := a.RawMacroSections[name]
+	return isVariable || isLabel || isMacro
+}
+
+func conditionsActive(conditions []conditionalBlock) bool {
+	return len(conditions) == 0 || conditions[len(conditions)-1].active
+}
+
+// Split `.if F_CPU > 20000000 .error "clock too fast"` into the condition and
+// the directive it guards, directive is empty for a block .if
+func splitInlineDirective(expr string) (condition string, directive string) {
+	quote := byte(0)
+	for i := 1; i < len(expr)-1; i++ {
+		switch {
+		case quote != 0:
+			if expr[i] == quote {
+				quote = 0
+			}
+		case expr[i] == '"' || expr[i] == '\'':
+			quote = expr[i]
+		case expr[i] == '.' && unicode.IsSpace(rune(expr[i-1])) && unicode.IsLetter(rune(expr[i+1])):
+			return strings.TrimSpace(expr[:i]), expr[i:]
+		}
+	}
+	return expr, ""
+}
+
+// Track conditional assembly, returns true when the line was a conditional
+// directive. A single line .if guarding a directive returns the line to
+// assemble in inline, with the condition blanked so columns still match
+func (a *Assembler) handleConditional(conditions *[]conditionalBlock, line string) (handled bool, inline string, err error) {
+	code := strings.TrimSpace(stripComment(line))
+	fields := strings.Fields(code)
+	if len(fields) == 0 {
+		return false, "", nil
+	}
+	directive := strings.ToLower(fields[0])
+	expr := strings.TrimSpace(code[len(fields[0]):])
+	enclosed := conditionsActive(*conditions)
+
+	switch directive {
+	case ".if", ".ifdef", ".ifndef":
+		expr, guarded := splitInlineDirective(expr)
+		if guarded != "" {
+			if name := strings.Fields(guarded)[0]; slices.Contains(ConditionalDirectives, strings.ToLower(name)) {
+				return true, "", syntaxError("%s cannot follow a single line %s", name, directive)
+			}
+		}
+		block := conditionalBlock{enclosed: enclosed}
+		// Branches inside skipped blocks are never evaluated
+		if enclosed {
+			if expr == "" {
+				return true, "", syntaxError("no condition given for %s", directive)
+			}
+			condition := false
+			switch directive {
+			case ".if":
+				value, err := evalExpression(expr, a.lookupExpressionSymbol)
+				if err != nil {
+					// Skip every branch so the matching .endif still closes the block
+					block.taken = true
+					if guarded == "" {
+						*conditions = append(*conditions, block)
+					}
+					return true, "", err
+				}
+				condition = value != 0
+			case ".ifdef":
+				condition = a.isSymbolDefined(expr)
+			case ".ifndef":
+				condition = !a.isSymbolDefined(expr)
+			}
+			block.active, block.taken = condition, condition
+		}
+		// A single line .if has no .endif
+		if guarded != "" {
+			if !block.active {
+				return true, "", nil
+			}
+			start := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace)) + len(code) - len(guarded)
+			return false, strings.Repeat(" ", start) + line[start:], nil
+		}
+		*conditions = append(*conditions, block)
+	case ".elif":
+		if len(*conditions) == 0 {
+			return true, "", syntaxError(".elif without .if")
+		}
+		block := &(*conditions)[len(*conditions)-1]
+		if block.inElse {
+			return true, "", syntaxError(".elif after .else")
+		}
+		block.active = false
+		if block.enclosed && !block.taken {
+			value, err := evalExpression(expr, a.lookupExpressionSymbol)
+			if err != nil {
+				return true, "", err
+			}
+			block.active, block.taken = value != 0, value != 0
+		}
+	case ".else":
+		if len(*conditions) == 0 {
+			return true, "", syntaxError(".else without .if")
+		}
+		block := &(*conditions)[len(*conditions)-1]
+		if block.inElse {
+			return true, "", syntaxError("duplicate .else")
+		}
+		block.inElse = true
+		block.active = block.enclosed && !block.taken
+		block.taken = true
+	case ".endif":
+		if len(*conditions) == 0 {
+			return true, "", syntaxError(".endif without .if")
+		}
+		*conditions = (*conditions)[:len(*conditions)-1]
+	default:
+		return false, "", nil
+	}
+	return true, "", nil
+}
+
+// Report a .error, .warning or .message directive
+func (a *Assembler) emitDiagnostic(operation string, args string, fn string, line int) error {
+	msg := parseMessage(args)
+	switch operation {
+	case "error":
+		return codeError(CodeErrorDirective, "%s", msg)
+	case "warning":
+		a.report(SeverityWarning, CodeWarningDirective, fn, line, msg)
+	case "message":
+		a.report(SeverityInfo, CodeMessageDirective, fn, line, msg)
+	}
+	return nil
+}
+
+// Evaluate every .assert now that all labels have addresses. Failed assertions
+// are reported, the error is only set once MaxErrors is reached
+func (a *Assembler) CheckAssertions() error {
+	for _, assertion := range a.Assertions {
+		a.scope = assertion.scope
+		value, err := evalExpression(assertion.Expression, a.lookupExpressionSymbol)
+		if err == nil && value == 0 {
+			msg := assertion.Message
+			if msg == "" {
+				msg = fmt.Sprintf("assertion [%s] failed", assertion.Expression)
+			}
+			err = codeError(CodeAssertion, "%s", msg)
+		}
+		if err != nil {
+			err = a.reportError(assertion.File, assertion.Line, err)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package avrassembler
+
+import (
+	"cmp"
+	"debug/elf"
+	"encoding/binary"
+	"slices"
+)
+
+// DWARF constants, debug/dwarf only exports the reading side
+const (
+	dwarfVersion         = 2
+	dwarfAddressSize     = 4
+	dwarfTagCompileUnit  = 0x11
+	dwarfAtName          = 0x03
+	dwarfAtStmtList      = 0x10
+	dwarfAtLowPC         = 0x11
+	dwarfAtHighPC        = 0x12
+	dwarfAtLanguage      = 0x13
+	dwarfAtProducer      = 0x25
+	dwarfFormAddr        = 0x01
+	dwarfFormData2       = 0x05
+	dwarfFormData4       = 0x06
+	dwarfFormString      = 0x08
+	dwarfLangAssembler   = 0x8001 // DW_LANG_Mips_Assembler, what GNU as emits
+	dwarfLineBase        = -5
+	dwarfLineRange       = 14
+	dwarfOpcodeBase      = 13
+	dwarfLNSCopy         = 0x01
+	dwarfLNSAdvancePC    = 0x02
+	dwarfLNSAdvanceLine  = 0x03
+	dwarfLNSSetFile      = 0x04
+	dwarfLNEEndSequence  = 0x01
+	dwarfLNESetAddress   = 0x02
+	dwarfProducer        = "avrassembler"
+	dwarfCompileUnitSize = 4 + 2 + 4 + 1
+)
+
+// Source location of one encoded instruction
+type lineRecord struct {
+	Address uint32 // Byte address
+	Size    uint32
+	File    string
+	Line    int
+}
+
+func appendULEB128(buf []byte, value uint64) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		if value != 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if value == 0 {
+			return buf
+		}
+	}
+}
+
+func appendSLEB128(buf []byte, value int64) []byte {
+	for {
+		b := byte(value & 0x7f)
+		value >>= 7
+		done := (value == 0 && b&0x40 == 0) || (value == -1 && b&0x40 != 0)
+		if !done {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if done {
+			return buf
+		}
+	}
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(append(buf, s...), 0)
+}
+
+// Every instruction in address order with the file and line it came from
+func (p *Program) collectLineRecords() []lineRecord {
+	records := []lineRecord{}
+	for _, instruction := range p.Instructions {
+		records = append(records, lineRecord{Address: instruction.Address, Size: uint32(len(instruction.Words) * 2), File: instruction.Source.File, Line: instruction.Source.Line})
+	}
+	slices.SortStableFunc(records, func(a, b lineRecord) int {
+		return cmp.Compare(a.Address, b.Address)
+	})
+	return records
+}
+
+// Line number program with one sequence per contiguous run of instructions
+func dwarfLineProgram(records []lineRecord, files []string) []byte {
+	lineBase := int8(dwarfLineBase)
+	header := []byte{1, 1, byte(lineBase), dwarfLineRange, dwarfOpcodeBase}
+	// Operand counts of the standard opcodes 1 to 12
+	header = append(header, 0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1)
+	header = append(header, 0) // No include directories, names are the paths the sources were read from
+	for _, file := range files {
+		header = appendString(header, file)
+		header = append(header, 0, 0, 0) // Directory, modification time, length
+	}
+	header = append(header, 0)
+
+	program := []byte{}
+	inSequence := false
+	address, file, line := uint32(0), 1, 1
+	for i, record := range records {
+		if !inSequence {
+			program = append(program, 0, 1+dwarfAddressSize, dwarfLNESetAddress)
+			program = binary.LittleEndian.AppendUint32(program, record.Address)
+			address, file, line, inSequence = record.Address, 1, 1, true
+		} else if record.Address > address {
+			program = append(program, dwarfLNSAdvancePC)
+			program = appendULEB128(program, uint64(record.Address-address))
+			address = record.Address
+		}
+		if fileIndex := slices.Index(files, record.File) + 1; fileIndex != file {
+			program = append(program, dwarfLNSSetFile)
+			program = appendULEB128(program, uint64(fileIndex))
+			file = fileIndex
+		}
+		if record.Line != line {
+			program = append(program, dwarfLNSAdvanceLine)
+			program = appendSLEB128(program, int64(record.Line-line))
+			line = record.Line
+		}
+		program = append(program, dwarfLNSCopy)
+
+		// Close the sequence at gaps left by .org or data and after the last instruction
+		end := record.Address + record.Size
+		if i == len(records)-1 || records[i+1].Address != end {
+			program = append(program, dwarfLNSAdvancePC)
+			program = appendULEB128(program, uint64(end-address))
+			program = append(program, 0, 1, dwarfLNEEndSequence)
+			inSequence = false
+		}
+	}
+
+	unit := binary.LittleEndian.AppendUint16(nil, dwarfVersion)
+	unit = binary.LittleEndian.AppendUint32(unit, uint32(len(header)))
+	unit = append(unit, header...)
+	unit = append(unit, program...)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(unit))), unit...)
+}
+
+// .debug_abbrev, .debug_info and .debug_line describing the source line of every
+// instruction, so avr-gdb and simavr can step through the assembly source
+func (p *Program) elfDebugSections() []elfSection {
+	records := p.collectLineRecords()
+	if len(records) == 0 {
+		return nil
+	}
+	files := []string{}
+	for _, record := range records {
+		if !slices.Contains(files, record.File) {
+			files = append(files, record.File)
+		}
+	}
+	name := files[0]
+	if len(p.Files) > 0 {
+		name = p.Files[0]
+	}
+	low := records[0].Address
+	last := records[len(records)-1]
+	high := last.Address + last.Size
+
+	abbrev := []byte{1, dwarfTagCompileUnit, 0} // Code 1, no children
+	for _, attribute := range [][2]byte{
+		{dwarfAtName, dwarfFormString},
+		{dwarfAtProducer, dwarfFormString},
+		{dwarfAtLanguage, dwarfFormData2},
+		{dwarfAtStmtList, dwarfFormData4},
+		{dwarfAtLowPC, dwarfFormAddr},
+		{dwarfAtHighPC, dwarfFormAddr},
+	} {
+		abbrev = append(abbrev, attribute[0], attribute[1])
+	}
+	abbrev = append(abbrev, 0, 0, 0)
+
+	die := []byte{1}
+	die = appendString(die, name)
+	die = appendString(die, dwarfProducer)
+	die = binary.LittleEndian.AppendUint16(die, dwarfLangAssembler)
+	die = binary.LittleEndian.AppendUint32(die, 0) // Offset of the line program
+	die = binary.LittleEndian.AppendUint32(die, low)
+	die = binary.LittleEndian.AppendUint32(die, high)
+
+	info := binary.LittleEndian.AppendUint32(nil, uint32(dwarfCompileUnitSize-4+len(die)))
+	info = binary.LittleEndian.AppendUint16(info, dwarfVersion)
+	info = binary.LittleEndian.AppendUint32(info, 0) // Offset into .debug_abbrev
+	info = append(info, dwarfAddressSize)
+	info = append(info, die...)
+
+	return []elfSection{
+		{Name: ".debug_abbrev", Type: elf.SHT_PROGBITS, Data: abbrev, Align: 1},
+		{Name: ".debug_info", Type: elf.SHT_PROGBITS, Data: info, Align: 1},
+		{Name: ".debug_line", Type: elf.SHT_PROGBITS, Data: dwarfLineProgram(records, files), Align: 1},
+	}
+}
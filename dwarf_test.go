@@ -0,0 +1,104 @@
+package avrassembler_test
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDWARFLineTable(t *testing.T) {
+	f := assembleELF(t, fstest.MapFS{
+		"main.S": {Data: []byte(`.macro twice
+nop
+nop
+.endmacro
+main: ldi r16, 1
+twice
+rjmp main
+.org 0x10
+.include "lib.inc"
+`)},
+		"lib.inc": {Data: []byte(`helper: dec r16
+ret
+`)},
+	})
+
+	data, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := data.Reader()
+	cu, err := units.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cu == nil || cu.Tag != dwarf.TagCompileUnit {
+		t.Fatalf("first entry is %v, want a compile unit", cu)
+	}
+	if name := cu.Val(dwarf.AttrName); name != "main.S" {
+		t.Errorf("compile unit is named %v, want main.S", name)
+	}
+	// The output doesn't depend on the directory the assembler ran in
+	if dir := cu.Val(dwarf.AttrCompDir); dir != nil {
+		t.Errorf("compile unit has DW_AT_comp_dir %v", dir)
+	}
+	lines, err := data.LineReader(cu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr uint64
+		want string // file:line, empty where no instruction was placed
+	}{
+		{addr: 0x0, want: "main.S:5"},
+		// Expanded macro lines point into the macro definition
+		{addr: 0x2, want: "main.S:2"},
+		{addr: 0x4, want: "main.S:3"},
+		{addr: 0x6, want: "main.S:7"},
+		// Gap up to the .org
+		{addr: 0x8, want: ""},
+		{addr: 0xe, want: ""},
+		{addr: 0x10, want: "lib.inc:1"},
+		{addr: 0x12, want: "lib.inc:2"},
+		{addr: 0x14, want: ""},
+	}
+	for _, tt := range tests {
+		entry := dwarf.LineEntry{}
+		err := lines.SeekPC(tt.addr, &entry)
+		got := ""
+		if err == nil {
+			got = fmt.Sprintf("%s:%d", entry.File.Name, entry.Line)
+		} else if !errors.Is(err, dwarf.ErrUnknownPC) {
+			t.Fatalf("seeking 0x%x: %v", tt.addr, err)
+		}
+		if got != tt.want {
+			t.Errorf("0x%x maps to %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+
+	// One sequence on each side of the .org gap
+	rows, sequences := 0, 0
+	entry := dwarf.LineEntry{}
+	lines.Reset()
+	for {
+		err := lines.Next(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.EndSequence {
+			sequences++
+		} else {
+			rows++
+		}
+	}
+	if rows != 6 || sequences != 2 {
+		t.Errorf("line table has %d rows in %d sequences, want 6 rows in 2", rows, sequences)
+	}
+}
@@ -0,0 +1,245 @@
+package avrassembler
+
+import (
+	"bytes"
+	"cmp"
+	"debug/elf"
+	"encoding/binary"
+	"slices"
+	"strings"
+)
+
+// Virtual address offsets avr-gcc uses to tell the memory spaces apart
+const (
+	ELFDataOffset   = 0x800000
+	ELFEEPROMOffset = 0x810000
+)
+
+// avr-gcc's default architecture (avr2) when no device is selected
+const defaultELFArchitecture = 2
+
+// Section written to the ELF file
+type elfSection struct {
+	Name      string
+	Type      elf.SectionType
+	Flags     elf.SectionFlag
+	Addr      uint32
+	Data      []byte
+	Size      uint32 // Only for SHT_NOBITS, otherwise len(Data)
+	Link      uint32
+	Info      uint32
+	Align     uint32
+	EntrySize uint32
+	Load      bool // Gets a PT_LOAD program header
+}
+
+// Symbol written to .symtab, Section is the name of the section it belongs to
+type elfSymbol struct {
+	Name    string
+	Value   uint32
+	Section string
+	Type    elf.SymType
+	Local   bool // File-local label, written as STB_LOCAL
+}
+
+// Sections for flash, SRAM reservations and EEPROM at avr-gcc's virtual addresses
+func (p *Program) elfMemorySections() ([]elfSection, error) {
+	sections := []elfSection{}
+	low, _ := p.Flash.Bounds()
+	text, err := toBinary(p.Flash, low, 0)
+	if err != nil {
+		return nil, err
+	}
+	sections = append(sections, elfSection{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Addr: low, Data: text, Align: 2, Load: true})
+
+	// .dseg only reserves space, so .data carries no contents
+	if p.DataEnd > p.DataStart {
+		sections = append(sections, elfSection{Name: ".data", Type: elf.SHT_NOBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Addr: ELFDataOffset + p.DataStart, Size: p.DataEnd - p.DataStart, Align: 1})
+	}
+
+	if p.EEPROM.Len() > 0 {
+		low, _ := p.EEPROM.Bounds()
+		data, err := toBinary(p.EEPROM, low, 0)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, elfSection{Name: ".eeprom", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Addr: ELFEEPROMOffset + low, Data: data, Align: 1, Load: true})
+	}
+	return sections, nil
+}
+
+// Labels become symbols in their section, .define and .equ constants are absolute
+func (p *Program) elfSymbols() []elfSymbol {
+	symbols := []elfSymbol{}
+	for _, symbol := range p.Symbols {
+		switch {
+		case symbol.Constant:
+			symbols = append(symbols, elfSymbol{Name: symbol.Name, Value: symbol.Value, Type: elf.STT_NOTYPE})
+		case symbol.Segment == CodeSegment:
+			symbols = append(symbols, elfSymbol{Name: symbol.Name, Value: symbol.Value, Section: ".text", Type: elf.STT_NOTYPE, Local: symbol.File != ""})
+		default:
+			offset := uint32(ELFDataOffset)
+			if symbol.Segment == EEPROMSegment {
+				offset = ELFEEPROMOffset
+			}
+			symbols = append(symbols, elfSymbol{Name: symbol.Name, Value: offset + symbol.Value, Section: SectionNames[symbol.Segment], Type: elf.STT_OBJECT, Local: symbol.File != ""})
+		}
+	}
+	// ELF wants every local symbol before the first global one
+	slices.SortFunc(symbols, func(a, b elfSymbol) int {
+		if a.Local != b.Local {
+			if a.Local {
+				return -1
+			}
+			return 1
+		}
+		if a.Value != b.Value {
+			return cmp.Compare(a.Value, b.Value)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return symbols
+}
+
+// Add a string to a string table and return its offset
+func elfString(table *[]byte, s string) uint32 {
+	offset := uint32(len(*table))
+	*table = append(*table, s...)
+	*table = append(*table, 0)
+	return offset
+}
+
+// Lay out an EM_AVR executable with the given sections, followed by
+// .symtab, .strtab and .shstrtab
+func toELF(sections []elfSection, symbols []elfSymbol, entry uint32, architecture uint32) ([]byte, error) {
+	sectionIndex := map[string]uint16{}
+	for i, section := range sections {
+		sectionIndex[section.Name] = uint16(i + 1)
+	}
+
+	strtab := []byte{0}
+	symtab := &bytes.Buffer{}
+	binary.Write(symtab, binary.LittleEndian, elf.Sym32{})
+	// Index of the first global symbol, after the null symbol and the locals
+	firstGlobal := uint32(1)
+	for _, symbol := range symbols {
+		index, ok := sectionIndex[symbol.Section]
+		if !ok {
+			index = uint16(elf.SHN_ABS)
+		}
+		binding := elf.STB_GLOBAL
+		if symbol.Local {
+			binding = elf.STB_LOCAL
+			firstGlobal++
+		}
+		binary.Write(symtab, binary.LittleEndian, elf.Sym32{
+			Name:  elfString(&strtab, symbol.Name),
+			Value: symbol.Value,
+			Info:  elf.ST_INFO(binding, symbol.Type),
+			Shndx: index,
+		})
+	}
+	symtabIndex := uint32(len(sections) + 1)
+	sections = append(sections,
+		elfSection{Name: ".symtab", Type: elf.SHT_SYMTAB, Data: symtab.Bytes(), Link: symtabIndex + 1, Info: firstGlobal, Align: 4, EntrySize: 16},
+		elfSection{Name: ".strtab", Type: elf.SHT_STRTAB, Data: strtab, Align: 1},
+	)
+
+	shstrtab := []byte{0}
+	names := []uint32{}
+	for _, section := range sections {
+		names = append(names, elfString(&shstrtab, section.Name))
+	}
+	names = append(names, elfString(&shstrtab, ".shstrtab"))
+	sections = append(sections, elfSection{Name: ".shstrtab", Type: elf.SHT_STRTAB, Data: shstrtab, Align: 1})
+
+	loadCount := 0
+	for _, section := range sections {
+		if section.Load {
+			loadCount++
+		}
+	}
+
+	const headerSize, programHeaderSize, sectionHeaderSize = 52, 32, 40
+	offset := uint32(headerSize + programHeaderSize*loadCount)
+	offsets := []uint32{}
+	for _, section := range sections {
+		if section.Align > 1 {
+			offset = (offset + section.Align - 1) / section.Align * section.Align
+		}
+		offsets = append(offsets, offset)
+		offset += uint32(len(section.Data))
+	}
+	sectionHeaderOffset := (offset + 3) / 4 * 4
+
+	out := &bytes.Buffer{}
+	header := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_AVR),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Shoff:     sectionHeaderOffset,
+		Flags:     architecture,
+		Ehsize:    headerSize,
+		Shentsize: sectionHeaderSize,
+		Shnum:     uint16(len(sections) + 1),
+		Shstrndx:  uint16(len(sections)),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if loadCount > 0 {
+		header.Phoff = headerSize
+		header.Phentsize = programHeaderSize
+		header.Phnum = uint16(loadCount)
+	}
+	binary.Write(out, binary.LittleEndian, header)
+
+	for i, section := range sections {
+		if !section.Load {
+			continue
+		}
+		flags := elf.PF_R | elf.PF_W
+		if section.Flags&elf.SHF_EXECINSTR != 0 {
+			flags = elf.PF_R | elf.PF_X
+		}
+		binary.Write(out, binary.LittleEndian, elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    offsets[i],
+			Vaddr:  section.Addr,
+			Paddr:  section.Addr,
+			Filesz: uint32(len(section.Data)),
+			Memsz:  uint32(len(section.Data)),
+			Flags:  uint32(flags),
+			Align:  1,
+		})
+	}
+
+	for i, section := range sections {
+		out.Write(make([]byte, int(offsets[i])-out.Len()))
+		out.Write(section.Data)
+	}
+	out.Write(make([]byte, int(sectionHeaderOffset)-out.Len()))
+
+	binary.Write(out, binary.LittleEndian, elf.Section32{})
+	for i, section := range sections {
+		size := uint32(len(section.Data))
+		if section.Type == elf.SHT_NOBITS {
+			size = section.Size
+		}
+		binary.Write(out, binary.LittleEndian, elf.Section32{
+			Name:      names[i],
+			Type:      uint32(section.Type),
+			Flags:     uint32(section.Flags),
+			Addr:      section.Addr,
+			Off:       offsets[i],
+			Size:      size,
+			Link:      section.Link,
+			Info:      section.Info,
+			Addralign: max(section.Align, 1),
+			Entsize:   section.EntrySize,
+		})
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,145 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+// Assemble main.S from files and return the ELF output parsed back
+func assembleELF(t *testing.T, files fstest.MapFS) *elf.File {
+	t.Helper()
+	_, program, err := assemble(t, avrassembler.Options{}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	err = program.WriteELF(out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestELFSections(t *testing.T) {
+	f := assembleELF(t, fstest.MapFS{"main.S": {Data: []byte(`.device atmega328p
+.dseg
+buf: .byte 4
+.eseg
+table: .db "AB"
+.cseg
+main: ldi r16, 1
+loop: rjmp loop
+`)}})
+
+	if f.Machine != elf.EM_AVR || f.Class != elf.ELFCLASS32 || f.Type != elf.ET_EXEC {
+		t.Fatalf("got machine %v class %v type %v, want an EM_AVR ELFCLASS32 executable", f.Machine, f.Class, f.Type)
+	}
+
+	tests := []struct {
+		name string
+		typ  elf.SectionType
+		addr uint64
+		size uint64
+		data []byte // nil for NOBITS sections
+	}{
+		{name: ".text", typ: elf.SHT_PROGBITS, addr: 0, size: 4, data: []byte{0x01, 0xe0, 0xff, 0xcf}},
+		{name: ".data", typ: elf.SHT_NOBITS, addr: 0x800100, size: 4},
+		{name: ".eeprom", typ: elf.SHT_PROGBITS, addr: 0x810000, size: 3, data: []byte{'A', 'B', 0}},
+	}
+	for _, tt := range tests {
+		section := f.Section(tt.name)
+		if section == nil {
+			t.Errorf("no %s section", tt.name)
+			continue
+		}
+		if section.Type != tt.typ || section.Addr != tt.addr || section.Size != tt.size {
+			t.Errorf("%s is %v at 0x%x with size %d, want %v at 0x%x with size %d", tt.name, section.Type, section.Addr, section.Size, tt.typ, tt.addr, tt.size)
+		}
+		if tt.data == nil {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			t.Errorf("reading %s: %v", tt.name, err)
+		} else if !bytes.Equal(data, tt.data) {
+			t.Errorf("%s holds % x, want % x", tt.name, data, tt.data)
+		}
+	}
+}
+
+func TestELFSymbols(t *testing.T) {
+	f := assembleELF(t, fstest.MapFS{
+		"main.S": {Data: []byte(`.device atmega328p
+.dseg
+.global buf
+buf: .byte 4
+.eseg
+table: .db "AB"
+.cseg
+.global main
+main: rcall delay
+loop: rjmp loop
+.include "delay.inc"
+`)},
+		"delay.inc": {Data: []byte(`.global delay
+delay: ldi r24, 10
+loop: dec r24
+brne loop
+ret
+`)},
+	})
+
+	symbols, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type symbol struct {
+		name    string
+		value   uint64
+		section string
+		bind    elf.SymBind
+	}
+	got := []symbol{}
+	for _, s := range symbols {
+		section := ""
+		if int(s.Section) < len(f.Sections) {
+			section = f.Sections[s.Section].Name
+		}
+		got = append(got, symbol{name: s.Name, value: s.Value, section: section, bind: elf.ST_BIND(s.Info)})
+	}
+	// Locals come first, each group by address
+	want := []symbol{
+		{name: "loop", value: 2, section: ".text", bind: elf.STB_LOCAL},
+		{name: "loop", value: 6, section: ".text", bind: elf.STB_LOCAL},
+		{name: "table", value: 0x810000, section: ".eeprom", bind: elf.STB_LOCAL},
+		{name: "main", value: 0, section: ".text", bind: elf.STB_GLOBAL},
+		{name: "delay", value: 4, section: ".text", bind: elf.STB_GLOBAL},
+		{name: "buf", value: 0x800100, section: ".data", bind: elf.STB_GLOBAL},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got symbols %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("symbol %d is %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	symtab := f.Section(".symtab")
+	if symtab == nil {
+		t.Fatal("no .symtab section")
+	}
+	// sh_info is one past the last local symbol, counting the null symbol
+	if symtab.Info != 4 {
+		t.Errorf(".symtab info is %d, want 4", symtab.Info)
+	}
+}
@@ -0,0 +1,162 @@
+package avrassembler
+
+import (
+	"fmt"
+)
+
+// Where in the source an error was found. Errors from operand parsers get
+// their file and line when they are reported, Line is 0 until then
+type Span struct {
+	File      string
+	Line      int
+	Column    int // First column starting at 1, 0 when unknown
+	EndColumn int // Column after the last one
+}
+
+func (s Span) position() Span {
+	return s
+}
+
+// Fill in the parts of the span that are still unknown
+func (s *Span) locate(at Span) {
+	if s.Line == 0 {
+		s.File, s.Line = at.File, at.Line
+	}
+	if s.Column == 0 {
+		s.Column, s.EndColumn = at.Column, at.EndColumn
+	}
+}
+
+// Source that could not be tokenized or a malformed directive or operand
+type SyntaxError struct {
+	Span
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+func (e *SyntaxError) DiagnosticCode() string {
+	return CodeSyntax
+}
+
+// Label or constant that was used but never defined
+type UndefinedSymbolError struct {
+	Span
+	Symbol     string
+	Kind       string         // label, variable, or symbol for constants and labels alike
+	Suggestion string         // Closest known name, empty when none is close
+	LocalTo    SourceLocation // Label by that name local to another file, File is empty when there is none
+}
+
+func (e *UndefinedSymbolError) Error() string {
+	return fmt.Sprintf("%s [%s] not found", e.Kind, e.Symbol)
+}
+
+func (e *UndefinedSymbolError) DiagnosticCode() string {
+	return CodeUndefinedSymbol
+}
+
+func (e *UndefinedSymbolError) relatedLocations() []RelatedLocation {
+	related := suggestionNote(e.Suggestion)
+	if e.LocalTo.File != "" {
+		related = append(related, RelatedLocation{Message: fmt.Sprintf("%s is local to %s, export it with .global %s", e.Symbol, e.LocalTo.File, e.Symbol), Source: e.LocalTo})
+	}
+	return related
+}
+
+func (e *UndefinedSymbolError) suggestion() string {
+	return e.Suggestion
+}
+
+// Register, immediate, bit number, I/O address or branch distance outside the
+// range the instruction or directive accepts
+type RangeError struct {
+	Span
+	What  string // register, relative address, I/O address, bit, value, ...
+	Value int64
+	Min   int64
+	Max   int64
+}
+
+func (e *RangeError) Error() string {
+	if e.What == "register" {
+		return fmt.Sprintf("register r%d is out of range, expected r%d to r%d", e.Value, e.Min, e.Max)
+	}
+	return fmt.Sprintf("%s %d is out of range, expected %d to %d", e.What, e.Value, e.Min, e.Max)
+}
+
+func (e *RangeError) DiagnosticCode() string {
+	switch e.What {
+	case "register":
+		return CodeRegisterRange
+	case "relative address":
+		return CodeBranchRange
+	}
+	return CodeValueRange
+}
+
+// Mnemonic the assembler has no encoding for
+type UnsupportedInstructionError struct {
+	Span
+	Mnemonic   string
+	Suggestion string // Closest instruction or macro, empty when none is close
+}
+
+func (e *UnsupportedInstructionError) Error() string {
+	return fmt.Sprintf("unsupported instruction %s", e.Mnemonic)
+}
+
+func (e *UnsupportedInstructionError) DiagnosticCode() string {
+	return CodeUnknownInstruction
+}
+
+func (e *UnsupportedInstructionError) relatedLocations() []RelatedLocation {
+	return suggestionNote(e.Suggestion)
+}
+
+func (e *UnsupportedInstructionError) suggestion() string {
+	return e.Suggestion
+}
+
+// Did you mean note, a related location without a source
+func suggestionNote(suggestion string) []RelatedLocation {
+	if suggestion == "" {
+		return nil
+	}
+	return []RelatedLocation{{Message: fmt.Sprintf("did you mean %s?", suggestion)}}
+}
+
+// Label defined a second time
+type DuplicateSymbolError struct {
+	Span
+	Symbol   string
+	Previous SourceLocation // Where the label was first defined
+}
+
+func (e *DuplicateSymbolError) Error() string {
+	return fmt.Sprintf("label %s is already defined at %s:%d", e.Symbol, e.Previous.File, e.Previous.Line)
+}
+
+func (e *DuplicateSymbolError) DiagnosticCode() string {
+	return CodeDuplicateSymbol
+}
+
+func (e *DuplicateSymbolError) relatedLocations() []RelatedLocation {
+	return []RelatedLocation{{Message: fmt.Sprintf("%s first defined here", e.Symbol), Source: e.Previous}}
+}
+
+// SyntaxError located where it is reported
+func syntaxError(format string, args ...any) error {
+	return &SyntaxError{Message: fmt.Sprintf(format, args...)}
+}
+
+// SyntaxError pointing at columns column up to endColumn
+func syntaxErrorAt(column int, endColumn int, format string, args ...any) error {
+	return &SyntaxError{Span: Span{Column: column, EndColumn: endColumn}, Message: fmt.Sprintf(format, args...)}
+}
+
+func rangeError(what string, value int64, min int64, max int64) error {
+	return &RangeError{What: what, Value: value, Min: min, Max: max}
+}
@@ -0,0 +1,249 @@
+package avrassembler
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Resolve a symbol name inside an expression to its value
+type SymbolLookup func(name string) (value int64, err error)
+
+type expressionParser struct {
+	input  string
+	pos    int
+	lookup SymbolLookup
+}
+
+// Binary operators grouped from lowest to highest precedence
+var expressionPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// Evaluate a C style integer expression such as `F_CPU / 1000 > 20000`.
+// Comparisons and logical operators yield 1 or 0
+func evalExpression(expr string, lookup SymbolLookup) (value int64, err error) {
+	p := expressionParser{input: expr, lookup: lookup}
+	value, err = p.parseBinary(0)
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return 0, syntaxError("unexpected [%s] in expression [%s]", p.input[p.pos:], expr)
+	}
+	return value, nil
+}
+
+func (p *expressionParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// Match the longest operator at the cursor if it belongs to this precedence level,
+// so `<` is never mistaken for the start of `<<`
+func (p *expressionParser) matchOperator(level int) string {
+	p.skipSpace()
+	rest := p.input[p.pos:]
+	longest := ""
+	for _, ops := range expressionPrecedence {
+		for _, op := range ops {
+			if strings.HasPrefix(rest, op) && len(op) > len(longest) {
+				longest = op
+			}
+		}
+	}
+	for _, op := range expressionPrecedence[level] {
+		if op == longest {
+			p.pos += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *expressionParser) parseBinary(level int) (value int64, err error) {
+	if level == len(expressionPrecedence) {
+		return p.parseUnary()
+	}
+	value, err = p.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.matchOperator(level)
+		if op == "" {
+			return value, nil
+		}
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		value, err = applyOperator(op, value, rhs)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func applyOperator(op string, lhs int64, rhs int64) (int64, error) {
+	boolean := func(b bool) int64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return boolean(lhs != 0 || rhs != 0), nil
+	case "&&":
+		return boolean(lhs != 0 && rhs != 0), nil
+	case "|":
+		return lhs | rhs, nil
+	case "^":
+		return lhs ^ rhs, nil
+	case "&":
+		return lhs & rhs, nil
+	case "==":
+		return boolean(lhs == rhs), nil
+	case "!=":
+		return boolean(lhs != rhs), nil
+	case "<":
+		return boolean(lhs < rhs), nil
+	case "<=":
+		return boolean(lhs <= rhs), nil
+	case ">":
+		return boolean(lhs > rhs), nil
+	case ">=":
+		return boolean(lhs >= rhs), nil
+	case "<<", ">>":
+		if rhs < 0 || rhs >= 64 {
+			return 0, codeError(CodeValueRange, "shift by %d in expression, expected 0 to 63", rhs)
+		}
+		if op == "<<" {
+			return lhs << rhs, nil
+		}
+		return lhs >> rhs, nil
+	case "+":
+		return lhs + rhs, nil
+	case "-":
+		return lhs - rhs, nil
+	case "*":
+		return lhs * rhs, nil
+	case "/", "%":
+		if rhs == 0 {
+			return 0, codeError(CodeValueRange, "division by zero in expression")
+		}
+		if op == "/" {
+			return lhs / rhs, nil
+		}
+		return lhs % rhs, nil
+	}
+	return 0, syntaxError("unknown operator %s", op)
+}
+
+func (p *expressionParser) parseUnary() (value int64, err error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0, syntaxError("unexpected end of expression [%s]", p.input)
+	}
+	switch p.input[p.pos] {
+	case '-', '~', '!':
+		op := p.input[p.pos]
+		p.pos++
+		value, err = p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '-':
+			return -value, nil
+		case '~':
+			return ^value, nil
+		default:
+			if value == 0 {
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case '(':
+		p.pos++
+		value, err = p.parseBinary(0)
+		if err != nil {
+			return 0, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return 0, syntaxError("missing ) in expression [%s]", p.input)
+		}
+		p.pos++
+		return value, nil
+	}
+	return p.parsePrimary()
+}
+
+func isSymbolChar(c byte) bool {
+	return c == '_' || c == '.' || c == '$' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func (p *expressionParser) parsePrimary() (value int64, err error) {
+	start := p.pos
+	if p.input[p.pos] == '\'' {
+		// Character literal 'A'
+		if p.pos+2 >= len(p.input) || p.input[p.pos+2] != '\'' {
+			return 0, syntaxError("malformed character literal in [%s]", p.input)
+		}
+		p.pos += 3
+		return int64(p.input[start+1]), nil
+	}
+	for p.pos < len(p.input) && isSymbolChar(p.input[p.pos]) {
+		p.pos++
+	}
+	word := p.input[start:p.pos]
+	if word == "" {
+		return 0, syntaxError("unexpected [%s] in expression [%s]", p.input[start:], p.input)
+	}
+
+	if unicode.IsDigit(rune(word[0])) {
+		base, digits := 10, word
+		if strings.HasPrefix(word, "0x") || strings.HasPrefix(word, "0X") {
+			base, digits = 16, word[2:]
+		} else if strings.HasPrefix(word, "0b") || strings.HasPrefix(word, "0B") {
+			base, digits = 2, word[2:]
+		}
+		number, err := strconv.ParseInt(digits, base, 64)
+		if err != nil {
+			return 0, syntaxError("invalid number [%s] in expression", word)
+		}
+		return number, nil
+	}
+
+	// HIGH(x) and LOW(x) select a byte of a word value
+	p.skipSpace()
+	upper := strings.ToUpper(word)
+	if (upper == "HIGH" || upper == "LOW") && p.pos < len(p.input) && p.input[p.pos] == '(' {
+		value, err = p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if upper == "HIGH" {
+			return (value >> 8) & 0xff, nil
+		}
+		return value & 0xff, nil
+	}
+
+	if p.lookup == nil {
+		return 0, &UndefinedSymbolError{Symbol: strings.TrimPrefix(word, "$"), Kind: "symbol"}
+	}
+	return p.lookup(strings.TrimPrefix(word, "$"))
+}
@@ -0,0 +1,76 @@
+package avrassembler
+
+import (
+	"testing"
+)
+
+func TestEvalExpression(t *testing.T) {
+	symbols := map[string]int64{"F_CPU": 16000000, "BAUD": 9600, "ADDR": 0x1234}
+	lookup := func(name string) (int64, error) {
+		value, ok := symbols[name]
+		if !ok {
+			return 0, &UndefinedSymbolError{Symbol: name, Kind: "symbol"}
+		}
+		return value, nil
+	}
+
+	tests := []struct {
+		expr string
+		want int64
+		code string // Diagnostic code of the error, empty when the expression is valid
+	}{
+		// Precedence
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "10 - 4 - 3", want: 3},
+		{expr: "1 << 2 + 1", want: 8},
+		{expr: "1 | 2 & 3", want: 3},
+		{expr: "1 + 1 == 2 && 3 > 2", want: 1},
+		{expr: "0 || 2 < 1", want: 0},
+		{expr: "-2 * -3", want: 6},
+		{expr: "!0 + ~0", want: 0},
+		{expr: "F_CPU / 16 / BAUD - 1", want: 103},
+		{expr: "F_CPU > 20000000", want: 0},
+		{expr: "0x10 + 0b11 + 'A'", want: 0x10 + 3 + 65},
+		// Shifts
+		{expr: "1 << 40", want: 1 << 40},
+		{expr: "0x100 >> 4", want: 0x10},
+		{expr: "-8 >> 1", want: -4},
+		{expr: "1 << 63", want: -1 << 63},
+		{expr: "1 << -1", code: CodeValueRange},
+		{expr: "1 >> -3", code: CodeValueRange},
+		{expr: "1 << 64", code: CodeValueRange},
+		// Division by zero
+		{expr: "7 / 2", want: 3},
+		{expr: "7 % 4", want: 3},
+		{expr: "1 / 0", code: CodeValueRange},
+		{expr: "1 % (2 - 2)", code: CodeValueRange},
+		// HIGH and LOW
+		{expr: "HIGH(ADDR)", want: 0x12},
+		{expr: "LOW(ADDR)", want: 0x34},
+		{expr: "high(0xabcd) + 1", want: 0xac},
+		{expr: "LOW(ADDR + 0xff)", want: 0x33},
+		// Malformed
+		{expr: "(1 + 2", code: CodeSyntax},
+		{expr: "1 +", code: CodeSyntax},
+		{expr: "1 2", code: CodeSyntax},
+		{expr: "0xzz", code: CodeSyntax},
+		{expr: "MISSING + 1", code: CodeUndefinedSymbol},
+	}
+	for _, tt := range tests {
+		got, err := evalExpression(tt.expr, lookup)
+		if tt.code != "" {
+			if err == nil {
+				t.Errorf("%s = %d, want error %s", tt.expr, got, tt.code)
+			} else if code := errorCode(err); code != tt.code {
+				t.Errorf("%s failed with %s (%v), want %s", tt.expr, code, err, tt.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package avrassembler
+
+import (
+	"debug/elf"
+	"fmt"
+	"slices"
+	"strings"
+
+	simplelog "github.com/ReidRise/simplelogger"
+)
+
+// Virtual addresses avr-gcc gives the fuse, lock and signature sections
+const (
+	ELFFuseOffset      = 0x820000
+	ELFLockOffset      = 0x830000
+	ELFSignatureOffset = 0x840000
+)
+
+// Fuse or lock byte of a device. Fuse bits are programmed when 0, so like the
+// avr-libc FUSE_ macros every setting is the whole byte with only its own bits
+// cleared and settings are combined with &
+type FuseByte struct {
+	Name     string // low, high, extended or lock
+	Default  byte   // Factory value
+	Settings map[string]byte
+	Hazards  []FuseHazard
+}
+
+// Bit pattern that leaves the device hard to reprogram
+type FuseHazard struct {
+	Mask    byte
+	Value   byte // Bits under Mask that trigger the warning
+	Warning string
+}
+
+// Hazards shared by most devices
+var (
+	spienHazard    = FuseHazard{Mask: 0x20, Value: 0x20, Warning: "SPIEN is unprogrammed, serial programming will be disabled and only a high voltage programmer can recover the device"}
+	rstdisblHazard = FuseHazard{Mask: 0x80, Value: 0x00, Warning: "RSTDISBL is programmed, the reset pin becomes I/O and serial programming is disabled"}
+	dwenHazard     = FuseHazard{Mask: 0x40, Value: 0x00, Warning: "DWEN is programmed, debugWIRE takes over the reset pin and disables serial programming"}
+	extClockHazard = FuseHazard{Mask: 0x0f, Value: 0x00, Warning: "CKSEL selects an external clock, the device will not run or program without one"}
+)
+
+// Lock bits of devices with a boot loader section
+var bootLockBits = FuseByte{Name: "lock", Default: 0xff, Settings: map[string]byte{
+	"LB_MODE_1": 0xff, "LB_MODE_2": 0xfe, "LB_MODE_3": 0xfc,
+	"BLB0_MODE_1": 0xff, "BLB0_MODE_2": 0xfb, "BLB0_MODE_3": 0xf3, "BLB0_MODE_4": 0xf7,
+	"BLB1_MODE_1": 0xff, "BLB1_MODE_2": 0xef, "BLB1_MODE_3": 0xcf, "BLB1_MODE_4": 0xdf,
+}}
+
+// Clock division, output and start up settings of the newer low fuse layout
+var lowFuseSettings = map[string]byte{
+	"CKDIV8": 0x7f, "CKOUT": 0xbf,
+	"SUT_0MS": 0xcf, "SUT_4MS": 0xdf, "SUT_65MS": 0xef,
+	"CKSEL_EXT": 0xf0, "CKSEL_INT8MHZ": 0xf2, "CKSEL_INT128KHZ": 0xf3, "CKSEL_XTAL": 0xff,
+}
+
+var bodLevelSettings = map[string]byte{
+	"BODLEVEL_DISABLED": 0xff, "BODLEVEL_1V8": 0xfe, "BODLEVEL_2V7": 0xfd, "BODLEVEL_4V3": 0xfc,
+}
+
+var atmega8515Fuses = []FuseByte{
+	{Name: "low", Default: 0xe1, Hazards: []FuseHazard{extClockHazard}, Settings: map[string]byte{
+		"BODLEVEL": 0x7f, "BODEN": 0xbf,
+		"SUT_0MS": 0xcf, "SUT_4MS": 0xdf, "SUT_65MS": 0xef,
+		"CKSEL_EXT": 0xf0, "CKSEL_INT1MHZ": 0xf1, "CKSEL_INT2MHZ": 0xf2, "CKSEL_INT4MHZ": 0xf3, "CKSEL_INT8MHZ": 0xf4, "CKSEL_XTAL": 0xff,
+	}},
+	{Name: "high", Default: 0xd9, Hazards: []FuseHazard{spienHazard}, Settings: map[string]byte{
+		"S8515C": 0x7f, "WDTON": 0xbf, "SPIEN": 0xdf, "CKOPT": 0xef, "EESAVE": 0xf7, "BOOTRST": 0xfe,
+		"BOOTSZ_128W": 0xff, "BOOTSZ_256W": 0xfd, "BOOTSZ_512W": 0xfb, "BOOTSZ_1024W": 0xf9,
+	}},
+}
+
+var atmega328pFuses = []FuseByte{
+	{Name: "low", Default: 0x62, Hazards: []FuseHazard{extClockHazard}, Settings: lowFuseSettings},
+	{Name: "high", Default: 0xd9, Hazards: []FuseHazard{rstdisblHazard, dwenHazard, spienHazard}, Settings: map[string]byte{
+		"RSTDISBL": 0x7f, "DWEN": 0xbf, "SPIEN": 0xdf, "WDTON": 0xef, "EESAVE": 0xf7, "BOOTRST": 0xfe,
+		"BOOTSZ_256W": 0xff, "BOOTSZ_512W": 0xfd, "BOOTSZ_1024W": 0xfb, "BOOTSZ_2048W": 0xf9,
+	}},
+	{Name: "extended", Default: 0xff, Settings: bodLevelSettings},
+}
+
+var atmega2560Fuses = []FuseByte{
+	{Name: "low", Default: 0x62, Hazards: []FuseHazard{extClockHazard}, Settings: lowFuseSettings},
+	{Name: "high", Default: 0x99, Hazards: []FuseHazard{spienHazard}, Settings: map[string]byte{
+		"OCDEN": 0x7f, "JTAGEN": 0xbf, "SPIEN": 0xdf, "WDTON": 0xef, "EESAVE": 0xf7, "BOOTRST": 0xfe,
+		"BOOTSZ_512W": 0xff, "BOOTSZ_1024W": 0xfd, "BOOTSZ_2048W": 0xfb, "BOOTSZ_4096W": 0xf9,
+	}},
+	{Name: "extended", Default: 0xff, Settings: bodLevelSettings},
+}
+
+var attiny85Fuses = []FuseByte{
+	{Name: "low", Default: 0x62, Hazards: []FuseHazard{extClockHazard}, Settings: map[string]byte{
+		"CKDIV8": 0x7f, "CKOUT": 0xbf,
+		"SUT_0MS": 0xcf, "SUT_4MS": 0xdf, "SUT_65MS": 0xef,
+		"CKSEL_EXT": 0xf0, "CKSEL_PLL": 0xf1, "CKSEL_INT8MHZ": 0xf2, "CKSEL_INT128KHZ": 0xf4, "CKSEL_XTAL": 0xff,
+	}},
+	{Name: "high", Default: 0xdf, Hazards: []FuseHazard{rstdisblHazard, dwenHazard, spienHazard}, Settings: map[string]byte{
+		"RSTDISBL": 0x7f, "DWEN": 0xbf, "SPIEN": 0xdf, "WDTON": 0xef, "EESAVE": 0xf7,
+		"BODLEVEL_DISABLED": 0xff, "BODLEVEL_1V8": 0xfe, "BODLEVEL_2V7": 0xfd, "BODLEVEL_4V3": 0xfc,
+	}},
+	{Name: "extended", Default: 0xff, Settings: map[string]byte{"SELFPRGEN": 0xfe}},
+}
+
+var attiny85Lock = FuseByte{Name: "lock", Default: 0xff, Settings: map[string]byte{
+	"LB_MODE_1": 0xff, "LB_MODE_2": 0xfe, "LB_MODE_3": 0xfc,
+}}
+
+// Fuse or lock byte of the target device by name
+func (a *Assembler) findFuse(name string) (*FuseByte, error) {
+	if a.TargetDevice == nil {
+		return nil, codeError(CodeDevice, "fuses need a device, use -d or .device")
+	}
+	if name == "lock" {
+		return &a.TargetDevice.Lock, nil
+	}
+	names := []string{}
+	for i, fuse := range a.TargetDevice.Fuses {
+		if fuse.Name == name {
+			return &a.TargetDevice.Fuses[i], nil
+		}
+		names = append(names, fuse.Name)
+	}
+	return nil, codeError(CodeFuse, "%s has no %s fuse, fuses are %s", a.TargetDevice.Name, name, strings.Join(names, ", "))
+}
+
+// Evaluate `.fuse low = CKSEL_INT8MHZ & SUT_65MS` or `.lock = LB_MODE_3` and
+// warn about settings that lock out the programmer
+func (a *Assembler) setFuse(name string, expr string, fn string, line int) error {
+	fuse, err := a.findFuse(name)
+	if err != nil {
+		return err
+	}
+	what := name + " fuse"
+	if name == "lock" {
+		what = "lock bits"
+	}
+	value, err := evalExpression(expr, func(symbol string) (int64, error) {
+		if setting, ok := fuse.Settings[strings.ToUpper(symbol)]; ok {
+			return int64(setting), nil
+		}
+		value, err := a.lookupExpressionSymbol(symbol)
+		if err != nil {
+			settings := []string{}
+			for setting := range fuse.Settings {
+				settings = append(settings, setting)
+			}
+			slices.Sort(settings)
+			return 0, codeError(CodeFuse, "%s is not a setting of the %s %s, settings are %s", symbol, a.TargetDevice.Name, what, strings.Join(settings, ", "))
+		}
+		return value, nil
+	})
+	if err != nil {
+		return err
+	}
+	if value < 0 || value > 0xff {
+		return codeError(CodeFuse, "%s value 0x%x does not fit in a byte", what, value)
+	}
+	if previous, ok := a.FuseValues[name]; ok && previous != byte(value) {
+		a.report(SeverityWarning, CodeFuseChanged, fn, line, fmt.Sprintf("%s changed from 0x%02x to 0x%02x", what, previous, value),
+			RelatedLocation{Message: fmt.Sprintf("%s first set to 0x%02x here", what, previous), Source: a.fuseSources[name]})
+	}
+	for _, hazard := range fuse.Hazards {
+		if byte(value)&hazard.Mask == hazard.Value {
+			a.report(SeverityWarning, CodeFuseHazard, fn, line, fmt.Sprintf("%s 0x%02x, %s", what, value, hazard.Warning))
+		}
+	}
+	a.FuseValues[name] = byte(value)
+	if _, ok := a.fuseSources[name]; !ok {
+		a.fuseSources[name] = SourceLocation{File: fn, Line: line}
+	}
+	simplelog.Info(fmt.Sprintf("%s set to 0x%02x", what, value))
+	return nil
+}
+
+// Every fuse byte of the device in order, unset fuses keep their factory value
+func (p *Program) fuseBytes() []byte {
+	data := []byte{}
+	for _, fuse := range p.Device.Fuses {
+		value, ok := p.Fuses[fuse.Name]
+		if !ok {
+			value = fuse.Default
+		}
+		data = append(data, value)
+	}
+	return data
+}
+
+// Whether any fuse besides the lock byte was set
+func (p *Program) fusesDeclared() bool {
+	for name := range p.Fuses {
+		if name != "lock" {
+			return true
+		}
+	}
+	return false
+}
+
+// .fuse, .lock and .signature sections as avr-gcc lays them out
+func (p *Program) elfFuseSections() []elfSection {
+	if p.Device == nil {
+		return nil
+	}
+	sections := []elfSection{}
+	if p.fusesDeclared() {
+		sections = append(sections, elfSection{Name: ".fuse", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Addr: ELFFuseOffset, Data: p.fuseBytes(), Align: 1, Load: true})
+	}
+	if lock, ok := p.Fuses["lock"]; ok {
+		sections = append(sections, elfSection{Name: ".lock", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Addr: ELFLockOffset, Data: []byte{lock}, Align: 1, Load: true})
+	}
+	// avr-libc stores the signature lowest byte first
+	signature := []byte{p.Device.Signature[2], p.Device.Signature[1], p.Device.Signature[0]}
+	sections = append(sections, elfSection{Name: ".signature", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC, Addr: ELFSignatureOffset, Data: signature, Align: 1, Load: true})
+	return sections
+}
@@ -0,0 +1,95 @@
+package avrassembler
+
+import (
+	"slices"
+)
+
+// Sparse byte image of one memory space, filled from every section before output
+type MemoryImage struct {
+	bytes map[uint32]byte
+}
+
+func NewMemoryImage() *MemoryImage {
+	return &MemoryImage{bytes: map[uint32]byte{}}
+}
+
+// Place data at addr, later writes win over earlier ones. Check Overlap first
+// to keep earlier contents
+func (m *MemoryImage) Write(addr uint32, data []byte) {
+	for i, b := range data {
+		m.bytes[addr+uint32(i)] = b
+	}
+}
+
+// First address from addr to addr+size that already holds data
+func (m *MemoryImage) Overlap(addr uint32, size uint32) (first uint32, ok bool) {
+	for i := uint32(0); i < size; i++ {
+		if _, exists := m.bytes[addr+i]; exists {
+			return addr + i, true
+		}
+	}
+	return 0, false
+}
+
+// Little endian 16 bit words as stored in flash
+func (m *MemoryImage) WriteWords(addr uint32, words []uint16) {
+	m.Write(addr, wordBytes(words))
+}
+
+func wordBytes(words []uint16) []byte {
+	data := []byte{}
+	for _, w := range words {
+		data = append(data, byte(w), byte(w>>8))
+	}
+	return data
+}
+
+func (m *MemoryImage) Len() int {
+	return len(m.bytes)
+}
+
+// Lowest used address and one past the highest, both 0 for an empty image
+func (m *MemoryImage) Bounds() (low uint32, high uint32) {
+	addresses := m.addresses()
+	if len(addresses) == 0 {
+		return 0, 0
+	}
+	return addresses[0], addresses[len(addresses)-1] + 1
+}
+
+func (m *MemoryImage) addresses() []uint32 {
+	addresses := make([]uint32, 0, len(m.bytes))
+	for addr := range m.bytes {
+		addresses = append(addresses, addr)
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+// Contiguous runs of data sorted by address, adjacent and overlapping
+// sections are folded into a single segment
+func (m *MemoryImage) Segments() []DataBlob {
+	segments := []DataBlob{}
+	for _, addr := range m.addresses() {
+		last := len(segments) - 1
+		if last >= 0 && segments[last].Address+uint32(len(segments[last].Data)) == addr {
+			segments[last].Data = append(segments[last].Data, m.bytes[addr])
+			continue
+		}
+		segments = append(segments, DataBlob{Address: addr, Data: []byte{m.bytes[addr]}})
+	}
+	return segments
+}
+
+// Bytes from addr to addr+size, unused addresses read as 0xFF like erased flash
+func (m *MemoryImage) Read(addr uint32, size uint32) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		b, ok := m.bytes[addr+uint32(i)]
+		if !ok {
+			b = 0xff
+		}
+		data[i] = b
+	}
+	return data
+}
@@ -0,0 +1,120 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+func TestDeviceIncludeConstants(t *testing.T) {
+	_, program, err := assemble(t, avrassembler.Options{IncludeRoot: "inc"}, fstest.MapFS{
+		"main.S": {Data: []byte(`.include <m328Pdef.inc>
+ldi r16, XVAL
+out DDRB, r16
+out PORTB, r16
+ldi r17, $XVAL
+`)},
+		"inc/m328Pdef.inc": {Data: []byte(".equ PORTB = 0x05\n.equ DDRB = 0x04\n.equ XVAL = 0x42\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x02, 0xe4, 0x04, 0xb9, 0x05, 0xb9, 0x12, 0xe4}
+	if got := program.Flash.Read(0, uint32(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("flash holds % x, want % x", got, want)
+	}
+}
+
+func TestIncludeResolution(t *testing.T) {
+	files := fstest.MapFS{
+		"main.S": {Data: []byte(`.include "local.inc"
+.include "shared.inc"
+.include <m328Pdef.inc>
+`)},
+		"local.inc": {Data: []byte("ldi r16, 1\n")},
+		// A file next to the including one wins over the include paths
+		"lib/local.inc":  {Data: []byte("ldi r16, 9\n")},
+		"lib/shared.inc": {Data: []byte("ldi r17, 2\n")},
+		// Angle brackets only look in the include root
+		"m328Pdef.inc":     {Data: []byte("ldi r18, 9\n")},
+		"inc/m328Pdef.inc": {Data: []byte("ldi r18, 3\n")},
+	}
+	_, program, err := assemble(t, avrassembler.Options{IncludePaths: []string{"lib"}, IncludeRoot: "inc"}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x01, 0xe0, 0x12, 0xe0, 0x23, 0xe0}
+	if got := program.Flash.Read(0, uint32(program.Flash.Len())); !bytes.Equal(got, want) {
+		t.Errorf("flash holds % x, want % x", got, want)
+	}
+
+	_, _, err = assemble(t, avrassembler.Options{}, fstest.MapFS{"main.S": {Data: []byte(`.include "missing.inc"` + "\n")}})
+	if codes := errorCodes(err); !slices.Equal(codes, []string{avrassembler.CodeFileNotFound}) {
+		t.Errorf("missing include failed with %v, want %s", codes, avrassembler.CodeFileNotFound)
+	}
+}
+
+func TestIncludeOnce(t *testing.T) {
+	for _, marker := range []string{".once", "#pragma once"} {
+		asm, program, err := assemble(t, avrassembler.Options{}, fstest.MapFS{
+			"main.S":   {Data: []byte(".include \"util.inc\"\n.include \"util.inc\"\n")},
+			"util.inc": {Data: []byte(marker + "\nldi r16, 1\n")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []byte{0x01, 0xe0}
+		if got := program.Flash.Read(0, uint32(program.Flash.Len())); !bytes.Equal(got, want) {
+			t.Errorf("with %s flash holds % x, want % x", marker, got, want)
+		}
+		if diagnostics := asm.Diagnostics(); len(diagnostics) != 0 {
+			t.Errorf("with %s got diagnostics %v, want none", marker, diagnostics)
+		}
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	_, _, err := assemble(t, avrassembler.Options{}, fstest.MapFS{
+		"main.S": {Data: []byte(".include \"a.inc\"\n")},
+		"a.inc":  {Data: []byte(".include \"b.inc\"\n")},
+		"b.inc":  {Data: []byte("nop\n.include \"a.inc\"\n")},
+	})
+	var list avrassembler.ErrorList
+	if !errors.As(err, &list) || len(list) != 1 {
+		t.Fatalf("got %v, want one circular include error", err)
+	}
+	d := list[0]
+	if d.Code != avrassembler.CodeCircularInclude || d.Message != "circular import a.inc -> b.inc -> a.inc" {
+		t.Errorf("got %s %q, want %s for the a.inc, b.inc chain", d.Code, d.Message, avrassembler.CodeCircularInclude)
+	}
+	if d.Source.File != "b.inc" || d.Source.Line != 2 {
+		t.Errorf("reported at %s:%d, want b.inc:2", d.Source.File, d.Source.Line)
+	}
+}
+
+// The warning points at the second .include line and the related note at the first
+func TestRepeatedIncludeSite(t *testing.T) {
+	asm, _, err := assemble(t, avrassembler.Options{}, fstest.MapFS{
+		"main.S":    {Data: []byte(".include \"util.inc\"\nnop\n.include \"other.inc\"\n")},
+		"other.inc": {Data: []byte("nop\n.include \"util.inc\"\n")},
+		"util.inc":  {Data: []byte("ldi r16, 1\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := asm.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Code != avrassembler.CodeRepeatedInclude {
+		t.Fatalf("got diagnostics %v, want one repeated include warning", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.Source != (avrassembler.SourceLocation{File: "other.inc", Line: 2}) {
+		t.Errorf("warned at %+v, want other.inc:2", d.Source)
+	}
+	if len(d.Related) != 1 || d.Related[0].Source != (avrassembler.SourceLocation{File: "main.S", Line: 1}) {
+		t.Errorf("related %+v, want the first inclusion at main.S:1", d.Related)
+	}
+}
@@ -0,0 +1,84 @@
+package avrassembler
+
+// I/O registers by name as the device definition files spell them. Registers
+// in the I/O space have their I/O address, as used by IN and OUT, and extended
+// I/O registers their data address, as used by LDS and STS
+
+// UBRRH and UCSRC share an address
+var atmega8515IO = map[string]uint16{
+	"OSCCAL": 0x04, "PINE": 0x05, "DDRE": 0x06, "PORTE": 0x07, "ACSR": 0x08, "UBRRL": 0x09,
+	"UCSRB": 0x0A, "UCSRA": 0x0B, "UDR": 0x0C, "SPCR": 0x0D, "SPSR": 0x0E, "SPDR": 0x0F, "PIND": 0x10,
+	"DDRD": 0x11, "PORTD": 0x12, "PINC": 0x13, "DDRC": 0x14, "PORTC": 0x15, "PINB": 0x16, "DDRB": 0x17,
+	"PORTB": 0x18, "PINA": 0x19, "DDRA": 0x1A, "PORTA": 0x1B, "EECR": 0x1C, "EEDR": 0x1D, "EEARL": 0x1E,
+	"EEARH": 0x1F, "UBRRH": 0x20, "UCSRC": 0x20, "WDTCR": 0x21, "ICR1L": 0x24, "ICR1H": 0x25,
+	"OCR1BL": 0x28, "OCR1BH": 0x29, "OCR1AL": 0x2A, "OCR1AH": 0x2B, "TCNT1L": 0x2C, "TCNT1H": 0x2D,
+	"TCCR1B": 0x2E, "TCCR1A": 0x2F, "SFIOR": 0x30, "OCR0": 0x31, "TCNT0": 0x32, "TCCR0": 0x33,
+	"MCUCSR": 0x34, "MCUCR": 0x35, "EMCUCR": 0x36, "SPMCR": 0x37, "TIFR": 0x38, "TIMSK": 0x39,
+	"GIFR": 0x3A, "GICR": 0x3B, "SPL": 0x3D, "SPH": 0x3E, "SREG": 0x3F,
+}
+
+// Extended I/O starts at 0x60
+var atmega328pIO = map[string]uint16{
+	"PINB": 0x03, "DDRB": 0x04, "PORTB": 0x05, "PINC": 0x06, "DDRC": 0x07, "PORTC": 0x08, "PIND": 0x09,
+	"DDRD": 0x0A, "PORTD": 0x0B, "TIFR0": 0x15, "TIFR1": 0x16, "TIFR2": 0x17, "PCIFR": 0x1B,
+	"EIFR": 0x1C, "EIMSK": 0x1D, "GPIOR0": 0x1E, "EECR": 0x1F, "EEDR": 0x20, "EEARL": 0x21,
+	"EEARH": 0x22, "GTCCR": 0x23, "TCCR0A": 0x24, "TCCR0B": 0x25, "TCNT0": 0x26, "OCR0A": 0x27,
+	"OCR0B": 0x28, "GPIOR1": 0x2A, "GPIOR2": 0x2B, "SPCR": 0x2C, "SPSR": 0x2D, "SPDR": 0x2E,
+	"ACSR": 0x30, "SMCR": 0x33, "MCUSR": 0x34, "MCUCR": 0x35, "SPMCSR": 0x37, "SPL": 0x3D, "SPH": 0x3E,
+	"SREG": 0x3F, "WDTCSR": 0x60, "CLKPR": 0x61, "PRR": 0x64, "OSCCAL": 0x66, "PCICR": 0x68,
+	"EICRA": 0x69, "PCMSK0": 0x6B, "PCMSK1": 0x6C, "PCMSK2": 0x6D, "TIMSK0": 0x6E, "TIMSK1": 0x6F,
+	"TIMSK2": 0x70, "ADCL": 0x78, "ADCH": 0x79, "ADCSRA": 0x7A, "ADCSRB": 0x7B, "ADMUX": 0x7C,
+	"DIDR0": 0x7E, "DIDR1": 0x7F, "TCCR1A": 0x80, "TCCR1B": 0x81, "TCCR1C": 0x82, "TCNT1L": 0x84,
+	"TCNT1H": 0x85, "ICR1L": 0x86, "ICR1H": 0x87, "OCR1AL": 0x88, "OCR1AH": 0x89, "OCR1BL": 0x8A,
+	"OCR1BH": 0x8B, "TCCR2A": 0xB0, "TCCR2B": 0xB1, "TCNT2": 0xB2, "OCR2A": 0xB3, "OCR2B": 0xB4,
+	"ASSR": 0xB6, "TWBR": 0xB8, "TWSR": 0xB9, "TWAR": 0xBA, "TWDR": 0xBB, "TWCR": 0xBC, "TWAMR": 0xBD,
+	"UCSR0A": 0xC0, "UCSR0B": 0xC1, "UCSR0C": 0xC2, "UBRR0L": 0xC4, "UBRR0H": 0xC5, "UDR0": 0xC6,
+}
+
+// Ports H to L and timer 5 lie past 0xFF
+var atmega2560IO = map[string]uint16{
+	"PINA": 0x00, "DDRA": 0x01, "PORTA": 0x02, "PINB": 0x03, "DDRB": 0x04, "PORTB": 0x05, "PINC": 0x06,
+	"DDRC": 0x07, "PORTC": 0x08, "PIND": 0x09, "DDRD": 0x0A, "PORTD": 0x0B, "PINE": 0x0C, "DDRE": 0x0D,
+	"PORTE": 0x0E, "PINF": 0x0F, "DDRF": 0x10, "PORTF": 0x11, "PING": 0x12, "DDRG": 0x13, "PORTG": 0x14,
+	"TIFR0": 0x15, "TIFR1": 0x16, "TIFR2": 0x17, "TIFR3": 0x18, "TIFR4": 0x19, "TIFR5": 0x1A,
+	"PCIFR": 0x1B, "EIFR": 0x1C, "EIMSK": 0x1D, "GPIOR0": 0x1E, "EECR": 0x1F, "EEDR": 0x20,
+	"EEARL": 0x21, "EEARH": 0x22, "GTCCR": 0x23, "TCCR0A": 0x24, "TCCR0B": 0x25, "TCNT0": 0x26,
+	"OCR0A": 0x27, "OCR0B": 0x28, "GPIOR1": 0x2A, "GPIOR2": 0x2B, "SPCR": 0x2C, "SPSR": 0x2D,
+	"SPDR": 0x2E, "ACSR": 0x30, "OCDR": 0x31, "SMCR": 0x33, "MCUSR": 0x34, "MCUCR": 0x35,
+	"SPMCSR": 0x37, "RAMPZ": 0x3B, "EIND": 0x3C, "SPL": 0x3D, "SPH": 0x3E, "SREG": 0x3F, "WDTCSR": 0x60,
+	"CLKPR": 0x61, "PRR0": 0x64, "PRR1": 0x65, "OSCCAL": 0x66, "PCICR": 0x68, "EICRA": 0x69,
+	"EICRB": 0x6A, "PCMSK0": 0x6B, "PCMSK1": 0x6C, "PCMSK2": 0x6D, "TIMSK0": 0x6E, "TIMSK1": 0x6F,
+	"TIMSK2": 0x70, "TIMSK3": 0x71, "TIMSK4": 0x72, "TIMSK5": 0x73, "XMCRA": 0x74, "XMCRB": 0x75,
+	"ADCL": 0x78, "ADCH": 0x79, "ADCSRA": 0x7A, "ADCSRB": 0x7B, "ADMUX": 0x7C, "DIDR2": 0x7D,
+	"DIDR0": 0x7E, "DIDR1": 0x7F, "TCCR1A": 0x80, "TCCR1B": 0x81, "TCCR1C": 0x82, "TCNT1L": 0x84,
+	"TCNT1H": 0x85, "ICR1L": 0x86, "ICR1H": 0x87, "OCR1AL": 0x88, "OCR1AH": 0x89, "OCR1BL": 0x8A,
+	"OCR1BH": 0x8B, "OCR1CL": 0x8C, "OCR1CH": 0x8D, "TCCR3A": 0x90, "TCCR3B": 0x91, "TCCR3C": 0x92,
+	"TCNT3L": 0x94, "TCNT3H": 0x95, "ICR3L": 0x96, "ICR3H": 0x97, "OCR3AL": 0x98, "OCR3AH": 0x99,
+	"OCR3BL": 0x9A, "OCR3BH": 0x9B, "OCR3CL": 0x9C, "OCR3CH": 0x9D, "TCCR4A": 0xA0, "TCCR4B": 0xA1,
+	"TCCR4C": 0xA2, "TCNT4L": 0xA4, "TCNT4H": 0xA5, "ICR4L": 0xA6, "ICR4H": 0xA7, "OCR4AL": 0xA8,
+	"OCR4AH": 0xA9, "OCR4BL": 0xAA, "OCR4BH": 0xAB, "OCR4CL": 0xAC, "OCR4CH": 0xAD, "TCCR2A": 0xB0,
+	"TCCR2B": 0xB1, "TCNT2": 0xB2, "OCR2A": 0xB3, "OCR2B": 0xB4, "ASSR": 0xB6, "TWBR": 0xB8,
+	"TWSR": 0xB9, "TWAR": 0xBA, "TWDR": 0xBB, "TWCR": 0xBC, "TWAMR": 0xBD, "UCSR0A": 0xC0,
+	"UCSR0B": 0xC1, "UCSR0C": 0xC2, "UBRR0L": 0xC4, "UBRR0H": 0xC5, "UDR0": 0xC6, "UCSR1A": 0xC8,
+	"UCSR1B": 0xC9, "UCSR1C": 0xCA, "UBRR1L": 0xCC, "UBRR1H": 0xCD, "UDR1": 0xCE, "UCSR2A": 0xD0,
+	"UCSR2B": 0xD1, "UCSR2C": 0xD2, "UBRR2L": 0xD4, "UBRR2H": 0xD5, "UDR2": 0xD6, "PINH": 0x100,
+	"DDRH": 0x101, "PORTH": 0x102, "PINJ": 0x103, "DDRJ": 0x104, "PORTJ": 0x105, "PINK": 0x106,
+	"DDRK": 0x107, "PORTK": 0x108, "PINL": 0x109, "DDRL": 0x10A, "PORTL": 0x10B, "TCCR5A": 0x120,
+	"TCCR5B": 0x121, "TCCR5C": 0x122, "TCNT5L": 0x124, "TCNT5H": 0x125, "ICR5L": 0x126, "ICR5H": 0x127,
+	"OCR5AL": 0x128, "OCR5AH": 0x129, "OCR5BL": 0x12A, "OCR5BH": 0x12B, "OCR5CL": 0x12C,
+	"OCR5CH": 0x12D, "UCSR3A": 0x130, "UCSR3B": 0x131, "UCSR3C": 0x132, "UBRR3L": 0x134,
+	"UBRR3H": 0x135, "UDR3": 0x136,
+}
+
+// Every register is in the I/O space
+var attiny85IO = map[string]uint16{
+	"ADCSRB": 0x03, "ADCL": 0x04, "ADCH": 0x05, "ADCSRA": 0x06, "ADMUX": 0x07, "ACSR": 0x08,
+	"USICR": 0x0D, "USISR": 0x0E, "USIDR": 0x0F, "USIBR": 0x10, "GPIOR0": 0x11, "GPIOR1": 0x12,
+	"GPIOR2": 0x13, "DIDR0": 0x14, "PCMSK": 0x15, "PINB": 0x16, "DDRB": 0x17, "PORTB": 0x18,
+	"EECR": 0x1C, "EEDR": 0x1D, "EEARL": 0x1E, "EEARH": 0x1F, "PRR": 0x20, "WDTCR": 0x21, "DWDR": 0x22,
+	"DTPS1": 0x23, "DT1B": 0x24, "DT1A": 0x25, "CLKPR": 0x26, "PLLCSR": 0x27, "OCR0B": 0x28,
+	"OCR0A": 0x29, "TCCR0A": 0x2A, "OCR1B": 0x2B, "GTCCR": 0x2C, "OCR1C": 0x2D, "OCR1A": 0x2E,
+	"TCNT1": 0x2F, "TCCR1": 0x30, "OSCCAL": 0x31, "TCNT0": 0x32, "TCCR0B": 0x33, "MCUSR": 0x34,
+	"MCUCR": 0x35, "SPMCSR": 0x37, "TIFR": 0x38, "TIMSK": 0x39, "GIFR": 0x3A, "GIMSK": 0x3B,
+	"SPL": 0x3D, "SPH": 0x3E, "SREG": 0x3F,
+}
@@ -0,0 +1,127 @@
+package avrassembler
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Words or bytes shown on one listing row, longer data continues on the next rows
+const listingUnitsPerRow = 4
+
+// Segment column prefix, indexed by Segment
+var listingSegmentPrefix = []string{"C", "D", "E"}
+
+var identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// Listing of every source line with its file and line, address and encoded
+// contents. Code addresses and contents are in words, EEPROM in bytes. Included
+// files and macro expansions are indented below the line that pulled them in
+func (p *Program) toListing() string {
+	width := 0
+	for _, line := range p.Listing {
+		width = max(width, len(fmt.Sprintf("%s:%d", line.File, line.Line)))
+	}
+
+	listing := ""
+	for _, line := range p.Listing {
+		source := fmt.Sprintf("%s:%d", line.File, line.Line)
+		text := strings.Repeat("  ", line.Depth) + line.Text
+		if !line.Placed {
+			listing += fmt.Sprintf("%-*s  %8s  %-*s  %s\n", width, source, "", listingUnitsPerRow*5, "", text)
+			continue
+		}
+
+		rows := []string{""}
+		addresses := []uint32{line.Address}
+		switch line.Segment {
+		case CodeSegment:
+			data := p.Flash.Read(line.Address, line.Size)
+			for i := 0; i+1 < len(data); i += 2 {
+				if i > 0 && i%(listingUnitsPerRow*2) == 0 {
+					rows = append(rows, "")
+					addresses = append(addresses, line.Address+uint32(i))
+				}
+				rows[len(rows)-1] += fmt.Sprintf("%04x ", uint16(data[i])|uint16(data[i+1])<<8)
+			}
+			for i := range addresses {
+				addresses[i] /= 2
+			}
+		case EEPROMSegment:
+			for i, b := range p.EEPROM.Read(line.Address, line.Size) {
+				if i > 0 && i%listingUnitsPerRow == 0 {
+					rows = append(rows, "")
+					addresses = append(addresses, line.Address+uint32(i))
+				}
+				rows[len(rows)-1] += fmt.Sprintf("%02x ", b)
+			}
+		case DataSegment:
+			if line.Size > 0 {
+				rows[0] = fmt.Sprintf("[%d bytes]", line.Size)
+			}
+		}
+
+		for i, row := range rows {
+			address := fmt.Sprintf("%s:%06x", listingSegmentPrefix[line.Segment], addresses[i])
+			if i == 0 {
+				listing += fmt.Sprintf("%-*s  %8s  %-*s  %s\n", width, source, address, listingUnitsPerRow*5, row, text)
+			} else {
+				listing += strings.TrimRight(fmt.Sprintf("%-*s  %8s  %s", width, "", address, row), " ") + "\n"
+			}
+		}
+	}
+	return listing + "\n" + p.listingCrossReference()
+}
+
+// Every label and constant with its value, definition and the lines using it
+func (p *Program) listingCrossReference() string {
+	symbols := []Symbol{}
+	width := len("Symbol")
+	for _, symbol := range p.Symbols {
+		// Defines from Options.Defines have no source line
+		if symbol.Defined.File == "" {
+			continue
+		}
+		symbols = append(symbols, symbol)
+		width = max(width, len(symbol.Name))
+	}
+	slices.SortFunc(symbols, func(a, b Symbol) int {
+		return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+
+	references := map[string][]SourceLocation{}
+	for _, instruction := range p.Instructions {
+		for _, operand := range instruction.Operands {
+			for _, name := range identifierPattern.FindAllString(operand, -1) {
+				if !slices.Contains(references[name], instruction.Source) {
+					references[name] = append(references[name], instruction.Source)
+				}
+			}
+		}
+	}
+
+	table := fmt.Sprintf("%-*s  %-8s  %-10s  %s\n", width, "Symbol", "Value", "Segment", "Defined / Referenced")
+	for _, symbol := range symbols {
+		value, segment := fmt.Sprintf("%06x", symbol.Value), SegmentNames[symbol.Segment]
+		if symbol.Constant {
+			segment = "constant"
+		} else if symbol.Segment == CodeSegment {
+			value = fmt.Sprintf("%06x", symbol.Value/2)
+		}
+		table += fmt.Sprintf("%-*s  %-8s  %-10s  %s:%d", width, symbol.Name, value, segment, symbol.Defined.File, symbol.Defined.Line)
+		used := []string{}
+		for _, location := range references[symbol.Name] {
+			// A file-local label is only used by its own file
+			if symbol.File == "" || location.File == symbol.File {
+				used = append(used, fmt.Sprintf("%s:%d", location.File, location.Line))
+			}
+		}
+		if len(used) > 0 {
+			table += " / " + strings.Join(used, ", ")
+		}
+		table += "\n"
+	}
+	return table
+}
@@ -0,0 +1,76 @@
+package avrassembler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Usage line like avr-size -C, with the percentage when the device is known
+func mapUsage(name string, used uint32, limit uint32, contents string) string {
+	usage := fmt.Sprintf("%-8s %8d bytes", name+":", used)
+	if limit > 0 {
+		usage += fmt.Sprintf(" (%.1f%% Full)", float64(used)*100/float64(limit))
+		if used > limit {
+			usage += fmt.Sprintf(" exceeds the %d bytes available", limit)
+		}
+	}
+	return usage + "\n(" + contents + ")\n\n"
+}
+
+// Map file listing every section with the file that placed it, each file's share
+// of flash and EEPROM, every symbol by address and a memory usage summary
+func (p *Program) toMapFile() string {
+	sections := p.Sections
+	mapFile := "Sections\n\n"
+	mapFile += fmt.Sprintf("%-8s %-4s  %-8s  %-8s  %8s  %s\n", "Section", "Type", "Start", "End", "Size", "File")
+	for _, section := range sections {
+		mapFile += fmt.Sprintf("%-8s %-4s  0x%06x  0x%06x  %8d  %s\n", SectionNames[section.Segment], section.Kind, section.Start, section.End, section.End-section.Start, section.File)
+	}
+
+	// Per file totals, SRAM reservations are not tracked per file
+	usage := map[string][2]uint32{}
+	for _, section := range sections {
+		total := usage[section.File]
+		if section.Segment == CodeSegment {
+			total[0] += section.End - section.Start
+		} else {
+			total[1] += section.End - section.Start
+		}
+		usage[section.File] = total
+	}
+	files := slices.Clone(p.Files)
+	for file := range usage {
+		if !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	mapFile += "\nMemory usage by file\n\n"
+	mapFile += fmt.Sprintf("%8s  %8s  %s\n", "Flash", "EEPROM", "File")
+	for _, file := range files {
+		mapFile += fmt.Sprintf("%8d  %8d  %s\n", usage[file][0], usage[file][1], file)
+	}
+
+	mapFile += "\nSymbols\n\n"
+	mapFile += fmt.Sprintf("%-8s  %-8s  %s\n", "Address", "Section", "Symbol")
+	for _, symbol := range p.Symbols {
+		section := SectionNames[symbol.Segment]
+		if symbol.Constant {
+			section = "*ABS*"
+		}
+		mapFile += fmt.Sprintf("0x%06x  %-8s  %s\n", symbol.Value, section, symbol.Name)
+	}
+
+	flashLimit, sramLimit, eepromLimit := uint32(0), uint32(0), uint32(0)
+	mapFile += "\nAVR Memory Usage\n----------------\n"
+	if p.Device != nil {
+		flashLimit, sramLimit, eepromLimit = p.Device.FlashSize, p.Device.SRAMSize, p.Device.EEPROMSize
+		mapFile += fmt.Sprintf("Device: %s\n\n", p.Device.Name)
+	} else {
+		mapFile += "Device: unknown, select one with -d or .device to check the limits\n\n"
+	}
+	mapFile += mapUsage("Program", uint32(p.Flash.Len()), flashLimit, ".text")
+	mapFile += mapUsage("Data", p.DataEnd-p.DataStart, sramLimit, ".dseg reservations")
+	mapFile += mapUsage("EEPROM", uint32(p.EEPROM.Len()), eepromLimit, ".eeprom")
+	return strings.TrimSuffix(mapFile, "\n")
+}
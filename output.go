@@ -1,136 +1,376 @@
 package avrassembler
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 
 	simplelog "github.com/ReidRise/simplelogger"
 )
 
-func toIntelHex(compiledAssembly []string, startingAddress int) (string, error) {
+// Records are aligned to multiples of the record length like avr-objcopy output.
+// A type 04 extended linear address record is written whenever the upper 16 bits
+// of the address change, so no record wraps around a 64 KB boundary
+func toIntelHex(image *MemoryImage, recordLength int, entry *uint32) (string, error) {
+	if recordLength < 1 || recordLength > 255 {
+		return "", fmt.Errorf("hex record length %d must be between 1 and 255", recordLength)
+	}
 	intel_hex := ""
-	intel_header := ""
-	intel_line := ""
-	intel_length := startingAddress
-	for i := 0; i < len(compiledAssembly); i++ {
-		if i != 0 && i%8 == 0 || i == (len(compiledAssembly)-1) {
-			intel_line = intel_line + compiledAssembly[i]
-			hex_len := fmt.Sprintf("%02x", len(intel_line)/2)
-			hex_addr := fmt.Sprintf("%04x", intel_length)
-			intel_length += len(intel_line) / 2
-			intel_header = ":" + hex_len + hex_addr + "00"
-			intel_checksum, err := intelHexChecksum(intel_header[1:] + intel_line)
-			if err != nil {
-				return "", fmt.Errorf("failed to compute intel checksum for %s", intel_line)
+	upperAddress := uint32(0)
+	for _, segment := range image.Segments() {
+		addr := segment.Address
+		data := segment.Data
+		for len(data) > 0 {
+			if addr>>16 != upperAddress {
+				upperAddress = addr >> 16
+				intel_hex += intelHexRecord(0x04, 0, []byte{byte(upperAddress >> 8), byte(upperAddress)})
+			}
+			length := recordLength - int(addr%uint32(recordLength))
+			if remaining := int(0x10000 - (addr & 0xffff)); length > remaining {
+				length = remaining
 			}
-			intel_hex += intel_header + intel_line + intel_checksum + "\n"
-			intel_line = ""
-		} else {
-			intel_line = intel_line + compiledAssembly[i]
+			if length > len(data) {
+				length = len(data)
+			}
+			intel_hex += intelHexRecord(0x00, uint16(addr), data[:length])
+			addr += uint32(length)
+			data = data[length:]
 		}
 	}
-	//intel_hex += ":00000001FF"
+	if entry != nil {
+		intel_hex += intelHexRecord(0x05, 0, []byte{byte(*entry >> 24), byte(*entry >> 16), byte(*entry >> 8), byte(*entry)})
+	}
+	intel_hex += intelHexRecord(0x01, 0, nil)
 	return intel_hex, nil
 }
 
-func intelHexChecksum(line string) (res string, err error) {
-	decodedByteArray, err := hex.DecodeString(line)
-	if err != nil {
-		return "", err
-	}
+func intelHexRecord(recordType byte, addr uint16, data []byte) string {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), recordType}, data...)
+	return ":" + strings.ToUpper(hex.EncodeToString(record)) + intelHexChecksum(record) + "\n"
+}
+
+func intelHexChecksum(record []byte) string {
 	checksum := uint64(0)
-	for i := 0; i < len(decodedByteArray); i++ {
-		checksum = checksum + uint64(decodedByteArray[i])
+	for i := 0; i < len(record); i++ {
+		checksum = checksum + uint64(record[i])
 	}
 	checksum = checksum & 0xff
 	checksum = checksum ^ 0xff
 	checksum = (checksum + 1) & 0xff
-	return fmt.Sprintf("%02x", checksum), nil
+	return fmt.Sprintf("%02X", checksum)
 }
 
-func WriteToFile(fn string) (err error) {
-	fileOut := ""
+// Flat image from base to the last used byte, or to padTo when that is further.
+// Gaps are filled with 0xFF like erased flash
+func toBinary(image *MemoryImage, base uint32, padTo uint32) ([]byte, error) {
+	low, high := image.Bounds()
+	if image.Len() > 0 && low < base {
+		return nil, fmt.Errorf("data at 0x%04x is below the binary base address 0x%04x", low, base)
+	}
+	if padTo > 0 && high > padTo {
+		return nil, fmt.Errorf("image ends at 0x%04x, past the padded size 0x%04x", high, padTo)
+	}
+	end := max(high, padTo, base)
+	binary := bytes.Repeat([]byte{0xff}, int(end-base))
+	for _, segment := range image.Segments() {
+		copy(binary[segment.Address-base:], segment.Data)
+	}
+	return binary, nil
+}
+
+// Columns of the operand err is about. The parsers don't say which operand was
+// wrong, so unless err names a symbol or register found in one of them every
+// operand is pointed at
+func operandSpan(instruction Instruction, err error) (column int, endColumn int) {
+	if len(instruction.Operands) == 0 {
+		return instruction.Column, instruction.EndColumn
+	}
+	match := ""
+	var undefined *UndefinedSymbolError
+	var outOfRange *RangeError
+	if errors.As(err, &undefined) {
+		match = undefined.Symbol
+	} else if errors.As(err, &outOfRange) && outOfRange.What == "register" {
+		match = fmt.Sprintf("r%d", outOfRange.Value)
+	}
+	for _, o := range instruction.Operands {
+		if match != "" && strings.EqualFold(o.Value, match) || undefined != nil && strings.Contains(o.Value, match) {
+			return o.Column, o.EndColumn
+		}
+	}
+	return instruction.Operands[0].Column, instruction.Operands[len(instruction.Operands)-1].EndColumn
+}
+
+// Encode every assembly section and data blob into a single flash image.
+// Instructions that fail to encode are reported and left out, err is only set
+// once MaxErrors is reached
+func (a *Assembler) encodeFlash() (image *MemoryImage, records []InstructionRecord, err error) {
+	image = NewMemoryImage()
+	owners := map[uint32]SourceLocation{}
 	// Parse Operands with context of all labels
 	simplelog.Info("Begin Encoding...")
-	for _, rawSection := range RawAssemblySections {
-		addr := rawSection.Address
+	for _, rawSection := range a.RawAssemblySections {
 		instructionSection := rawSection.Assembly
-		compiledAssembly := []string{}
-		// Split into two loops to write from low->high addr
 		for i := 0; i < len(instructionSection); i++ {
-			parsingFunc, ok := InstructionParse[instructionSection[i].Mnemonic]
+			instruction := instructionSection[i]
+			fail := func(err error) error {
+				return a.reportError(instruction.File, instruction.Line, err)
+			}
+			parsingFunc, ok := InstructionParse[instruction.Mnemonic]
 			if !ok {
-				return fmt.Errorf("parsing function not found for %s not found on line %d of %s", instructionSection[i].Mnemonic, instructionSection[i].Line, instructionSection[i].File)
+				err = fail(&UnsupportedInstructionError{Span: Span{Column: instruction.Column, EndColumn: instruction.EndColumn}, Mnemonic: instruction.Mnemonic, Suggestion: a.suggestMnemonic(instruction.Mnemonic)})
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
 			}
 			operands := []string{}
-			for _, o := range instructionSection[i].Operands {
+			for _, o := range instruction.Operands {
 				operands = append(operands, o.Value)
 			}
 
-			ops, err := parsingFunc(operands, instructionSection[i].Address)
+			a.scope = instruction.scope
+			ops, err := parsingFunc(a, operands, int(instruction.Address))
 			if err != nil {
-				return fmt.Errorf("%s, Found on line %d of file %s", err, instructionSection[i].Line, instructionSection[i].File)
+				column, endColumn := operandSpan(instruction, err)
+				err = fail(&sourceError{Column: column, EndColumn: endColumn, Err: err})
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
 			}
 
-			ins, ok := InstructionSet[instructionSection[i].Mnemonic]
+			ins, ok := InstructionSet[instruction.Mnemonic]
 			if !ok {
-				return fmt.Errorf("encoding function not found for %s on line %d of %s", instructionSection[i].Mnemonic, instructionSection[i].Line, instructionSection[i].File)
+				err = fail(&UnsupportedInstructionError{Span: Span{Column: instruction.Column, EndColumn: instruction.EndColumn}, Mnemonic: instruction.Mnemonic, Suggestion: a.suggestMnemonic(instruction.Mnemonic)})
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
 			}
 
-			enc := ins.Encode(ins.ByteCode, ops[0], ops[1])
-
-			le_enc := ((enc[0] >> 8) & 0x00ff) | ((enc[0] << 8) & 0xff00)
-			hex := fmt.Sprintf("%x", le_enc)
-			hex = fmt.Sprintf("%04s", hex)
-			compiledAssembly = append(compiledAssembly, hex)
-			simplelog.Debug(fmt.Sprintf("%6s %04s", instructionSection[i].Mnemonic, hex))
+			words := []uint16{ins.Encode(ins.ByteCode, ops[0], ops[1])[0]}
 
 			// Extra handling for 32bit instructions
-			if slices.Contains(LongInstructions, instructionSection[i].Mnemonic) {
-				ins, ok := InstructionSet["_"+instructionSection[i].Mnemonic]
+			if slices.Contains(LongInstructions, instruction.Mnemonic) {
+				ins, ok := InstructionSet["_"+instruction.Mnemonic]
 				if !ok {
-					return fmt.Errorf("second encoding function not found for _%s", instructionSection[i].Mnemonic)
+					return nil, nil, fmt.Errorf("second encoding function not found for _%s", instruction.Mnemonic)
 				}
-				enc := ins.Encode(ins.ByteCode, ops[0], ops[1])
-				le_enc := ((enc[0] >> 8) & 0x00ff) | ((enc[0] << 8) & 0xff00)
-				hex := fmt.Sprintf("%x", le_enc)
-				hex = fmt.Sprintf("%04s", hex) // Pad small strings with 0s
-				compiledAssembly = append(compiledAssembly, hex)
+				words = append(words, ins.Encode(ins.ByteCode, ops[0], ops[1])[0])
+			}
+			simplelog.Debug(fmt.Sprintf("%6s %04x", instruction.Mnemonic, words))
+			source := SourceLocation{File: instruction.File, Line: instruction.Line}
+			placed, err := a.placeBytes(image, owners, instruction.Address*2, wordBytes(words), source)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !placed {
+				continue
+			}
+			records = append(records, InstructionRecord{
+				Address:  instruction.Address * 2,
+				Words:    words,
+				Mnemonic: instruction.Mnemonic,
+				Operands: operands,
+				Source:   source,
+			})
+		}
+	}
+	for _, dataBlob := range a.DbSections {
+		if dataBlob.Segment == CodeSegment {
+			_, err = a.placeBytes(image, owners, dataBlob.Address, dataBlob.Data, SourceLocation{File: dataBlob.File, Line: dataBlob.Line})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return image, records, nil
+}
+
+// Image of everything placed with .eseg, overlapping data is reported and the
+// error only set once MaxErrors is reached
+func (a *Assembler) encodeEEPROM() (*MemoryImage, error) {
+	image := NewMemoryImage()
+	owners := map[uint32]SourceLocation{}
+	for _, dataBlob := range a.DbSections {
+		if dataBlob.Segment == EEPROMSegment {
+			_, err := a.placeBytes(image, owners, dataBlob.Address, dataBlob.Data, SourceLocation{File: dataBlob.File, Line: dataBlob.Line})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return image, nil
+}
+
+// Code or data placed on bytes an earlier line already filled
+type overlapError struct {
+	Address  uint32
+	Previous SourceLocation
+}
+
+func (e *overlapError) Error() string {
+	return fmt.Sprintf("0x%04x is already used by %s:%d", e.Address, e.Previous.File, e.Previous.Line)
+}
+
+func (e *overlapError) DiagnosticCode() string {
+	return CodeOverlap
+}
+
+func (e *overlapError) relatedLocations() []RelatedLocation {
+	return []RelatedLocation{{Message: fmt.Sprintf("0x%04x first filled here", e.Address), Source: e.Previous}}
+}
+
+// Write data to image unless it lands on bytes placed before, owners records
+// the source of every byte. placed is false when the overlap was reported
+func (a *Assembler) placeBytes(image *MemoryImage, owners map[uint32]SourceLocation, addr uint32, data []byte, source SourceLocation) (placed bool, err error) {
+	if first, ok := image.Overlap(addr, uint32(len(data))); ok {
+		return false, a.reportError(source.File, source.Line, &overlapError{Address: first, Previous: owners[first]})
+	}
+	image.Write(addr, data)
+	for i := range data {
+		owners[addr+uint32(i)] = source
+	}
+	return true, nil
+}
+
+// EEPROM contents must fit the selected device
+func (a *Assembler) checkEEPROMSize(eeprom *MemoryImage) error {
+	if a.TargetDevice == nil || eeprom.Len() == 0 {
+		return nil
+	}
+	_, high := eeprom.Bounds()
+	if high > a.TargetDevice.EEPROMSize {
+		return codeError(CodeMemoryOverflow, "EEPROM data ends at 0x%04x, past the %d bytes of EEPROM on the %s", high, a.TargetDevice.EEPROMSize, a.TargetDevice.Name)
+	}
+	return nil
+}
+
+// Write the assembled program in OutputFormat along with the EEPROM, fuse,
+// listing and map files
+func (a *Assembler) WriteToFile(fn string) (err error) {
+	p := a.program
+	if p == nil {
+		return fmt.Errorf("nothing to write, Assemble the program first")
+	}
+	a.DumpLabelMap()
+	var entry *uint32
+	if a.EntryPoint != "" {
+		addr, err := p.EntryAddress(a.EntryPoint)
+		if err != nil {
+			return err
+		}
+		entry = &addr
+	}
+	padTo := uint32(0)
+	if a.PadToFlash && a.OutputFormat == "bin" {
+		if p.Device == nil {
+			return fmt.Errorf("padding to flash size needs a device, use -d or .device")
+		}
+		padTo = p.Device.FlashSize
+	}
+
+	err = writeFile(fn, func(w io.Writer) error {
+		switch a.OutputFormat {
+		case "hex":
+			return p.WriteHex(w, a.HexRecordLength, entry)
+		case "srec":
+			return p.WriteSRecord(w, a.HexRecordLength, filepath.Base(fn), entry)
+		case "elf":
+			start := uint32(0)
+			if entry != nil {
+				start = *entry
 			}
+			return p.WriteELF(w, start)
+		case "bin":
+			return p.WriteBinary(w, a.BinaryBase, padTo)
 		}
+		return fmt.Errorf("unknown output format %s", a.OutputFormat)
+	})
+	if err != nil {
+		return err
+	}
 
-		fileContent, err := toIntelHex(compiledAssembly, int(addr))
+	// avrdude programs EEPROM from its own file, -U eeprom:w:output.eep
+	base := strings.TrimSuffix(fn, filepath.Ext(fn))
+	if p.EEPROM.Len() > 0 {
+		eepromFile := a.EEPROMFile
+		if eepromFile == "" {
+			eepromFile = base + ".eep"
+		}
+		err = writeFile(eepromFile, func(w io.Writer) error {
+			return p.WriteEEPROMHex(w, a.HexRecordLength)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// Raw fuse bytes, low first, and the lock byte for the programmer
+	if p.fusesDeclared() {
+		err = writeFile(base+".fuse", p.WriteFuses)
+		if err != nil {
+			return err
+		}
+	}
+	if lock, ok := p.Fuses["lock"]; ok {
+		err = writeFile(base+".lock", func(w io.Writer) error {
+			_, err := w.Write([]byte{lock})
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		fileOut += fileContent
 	}
-	for _, dataBlob := range DbSections {
-		dataBlobString := []string{hex.EncodeToString(dataBlob.Data)}
-		fileContent, err := toIntelHex(dataBlobString, int(dataBlob.Address))
+	if a.ListingFile != "" {
+		err = writeFile(a.ListingFile, p.WriteListing)
 		if err != nil {
 			return err
 		}
-		fileOut += fileContent
 	}
-	DumpLabelMap()
-	fileOut += ":00000001FF"
-	simplelog.Debug("\n" + fileOut)
-	os.Remove(fn)
+	if a.MapFile != "" {
+		err = writeFile(a.MapFile, p.WriteMap)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create or truncate fn and fill it with write
+func writeFile(fn string, write func(w io.Writer) error) error {
 	f, err := os.Create(fn)
 	if err != nil {
 		return err
 	}
-	l, err := f.WriteString(fileOut)
+	counter := &countingWriter{w: f}
+	err = write(counter)
 	if err != nil {
+		f.Close()
 		return err
 	}
-	simplelog.Info(fmt.Sprintf("%d bytes written successfully", l))
 	err = f.Close()
 	if err != nil {
 		return err
 	}
+	simplelog.Info(fmt.Sprintf("%d bytes written to %s", counter.n, fn))
 	return nil
 }
+
+// Counts the bytes passed through for the log
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
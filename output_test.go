@@ -0,0 +1,75 @@
+package avrassembler
+
+import (
+	"strings"
+	"testing"
+)
+
+// Type 04 records come before the first record of each 64 KB block
+func TestIntelHexRecords(t *testing.T) {
+	bytesFrom := func(first byte, n int) []byte {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = first + byte(i)
+		}
+		return data
+	}
+	entry := uint32(0x20010)
+	tests := []struct {
+		name         string
+		blobs        []DataBlob
+		recordLength int
+		entry        *uint32
+		want         []string
+	}{
+		{
+			name:         "below 64 KB",
+			blobs:        []DataBlob{{Address: 0, Data: []byte{0x11, 0x22}}},
+			recordLength: 16,
+			want:         []string{":020000001122CB", ":00000001FF"},
+		},
+		{
+			name:         "split at the 64 KB boundary",
+			blobs:        []DataBlob{{Address: 0xfffc, Data: bytesFrom(0, 8)}},
+			recordLength: 16,
+			want:         []string{":04FFFC0000010203FB", ":020000040001F9", ":0400000004050607E6", ":00000001FF"},
+		},
+		{
+			name:         "32 byte records split at the 64 KB boundary",
+			blobs:        []DataBlob{{Address: 0xfff0, Data: bytesFrom(0, 32)}},
+			recordLength: 32,
+			want: []string{
+				":10FFF000000102030405060708090A0B0C0D0E0F89",
+				":020000040001F9",
+				":10000000101112131415161718191A1B1C1D1E1F78",
+				":00000001FF",
+			},
+		},
+		{
+			name:         "upper address before the first record",
+			blobs:        []DataBlob{{Address: 0x20010, Data: []byte{0xaa, 0xbb}}},
+			recordLength: 16,
+			entry:        &entry,
+			want:         []string{":020000040002F8", ":02001000AABB89", ":0400000500020010E5", ":00000001FF"},
+		},
+		{
+			name:         "one type 04 record per 64 KB block",
+			blobs:        []DataBlob{{Address: 0, Data: []byte{0x11, 0x22}}, {Address: 0x20010, Data: []byte{0xaa, 0xbb}}},
+			recordLength: 16,
+			want:         []string{":020000001122CB", ":020000040002F8", ":02001000AABB89", ":00000001FF"},
+		},
+	}
+	for _, tt := range tests {
+		image := NewMemoryImage()
+		for _, blob := range tt.blobs {
+			image.Write(blob.Address, blob.Data)
+		}
+		hex, err := toIntelHex(image, tt.recordLength, tt.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Fields(hex); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
@@ -2,8 +2,10 @@ package avrassembler
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
-	"os"
+	"io"
+	"io/fs"
 	"slices"
 	"strconv"
 	"strings"
@@ -21,12 +23,18 @@ const (
 )
 
 type Instruction struct {
-	Long     bool
-	Mnemonic string
-	Operands []Token
-	Address  int // Tracking jumps and branches
-	Line     int // For error reporting
-	File     string
+	Long      bool
+	Mnemonic  string
+	Operands  []Token
+	Address   uint32 // Word address for tracking jumps and branches
+	Line      int    // For error reporting
+	Column    int    // Columns of the mnemonic, for error reporting
+	EndColumn int
+	File      string
+	Text      string // Source line, for the listing
+
+	// Where labels in the operands are looked up
+	scope labelScope
 }
 
 // List of 32bit Instructions
@@ -34,14 +42,35 @@ var LongInstructions = []string{
 	"LDS",
 }
 
+// Instructions that never continue with the next one
+var FlowEndingInstructions = []string{
+	"RJMP", "JMP", "IJMP", "EIJMP", "RET", "RETI",
+}
+
+// Instructions that may skip the next one, which makes a jump after them conditional
+var SkipInstructions = []string{
+	"CPSE", "SBRC", "SBRS", "SBIC", "SBIS",
+}
+
+// Bytes of flash an instruction occupies
+func instructionSize(instruction Instruction) uint32 {
+	if slices.Contains(LongInstructions, instruction.Mnemonic) {
+		return 4
+	}
+	return 2
+}
+
 type Meta struct {
 	Operation  string
 	Args       string
 	NewSection bool
+	Column     int // Columns of the directive and its arguments, for error reporting
+	EndColumn  int
+	ArgSpans   []Span // Columns of each name in a .global or .extern list
 }
 
-func isMacro(macro string) (meta Meta, exists bool) {
-	_, ok := RawMacroSections[macro]
+func (a *Assembler) isMacro(macro string) (meta Meta, exists bool) {
+	_, ok := a.RawMacroSections[macro]
 	if ok {
 		meta.Operation = "invokeMacro"
 		meta.Args = macro
@@ -49,161 +78,616 @@ func isMacro(macro string) (meta Meta, exists bool) {
 	return meta, ok
 }
 
-func ParseFile(fn string, startAddress uint16) (handoverAddress uint16, err error) {
-	file, err := os.Open(fn)
+// Assemble a file from FS starting at the byte address startAddress and
+// return the address following its last instruction or data
+func (a *Assembler) ParseFile(fn string, startAddress uint32) (handoverAddress uint32, err error) {
+	file, err := a.FS.Open(fn)
 	if err != nil {
-		simplelog.Error(err.Error())
+		return 0, &sourceError{Code: CodeFileNotFound, Err: err}
 	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file)
+	return a.parseSource(fn, file, startAddress)
+}
+
+func (a *Assembler) parseSource(fn string, r io.Reader, startAddress uint32) (handoverAddress uint32, err error) {
+	canonical, err := a.canonicalPath(fn)
+	if err != nil {
+		return 0, err
+	}
+	for i, frame := range a.importStack {
+		if frame.Path == canonical {
+			chain := []string{}
+			for _, f := range a.importStack[i:] {
+				chain = append(chain, f.Name)
+			}
+			return 0, codeError(CodeCircularInclude, "circular import %s -> %s", strings.Join(chain, " -> "), fn)
+		}
+	}
+	if a.OnceFiles[canonical] {
+		simplelog.Info(fmt.Sprintf("Skipping File %s, already included and marked .once", fn))
+		return startAddress, nil
+	}
+	if len(a.importStack) > 0 {
+		parent := a.importStack[len(a.importStack)-1]
+		site := SourceLocation{File: parent.Name, Line: parent.Line}
+		if first, ok := a.includeSites[canonical]; ok {
+			a.report(SeverityWarning, CodeRepeatedInclude, site.File, site.Line, fmt.Sprintf("file %s is included more than once, mark it with .once to include it a single time", fn),
+				RelatedLocation{Message: fmt.Sprintf("%s first included here", fn), Source: first})
+		} else {
+			a.includeSites[canonical] = site
+		}
+		a.ImportGraph[parent.Path] = append(a.ImportGraph[parent.Path], canonical)
+	}
+	a.importStack = append(a.importStack, importFrame{Path: canonical, Name: fn})
+	if !slices.Contains(a.SourceFiles, fn) {
+		a.SourceFiles = append(a.SourceFiles, fn)
+	}
+	defer func() { a.importStack = a.importStack[:len(a.importStack)-1] }()
+
+	scanner := bufio.NewScanner(r)
 	instructions := []Instruction{}
 	// Collect Instuctions and Labels
 	inMacroDef := ""
 	// Line in file
 	codeLine := uint16(0)
 	// Line in raw assembly section
-	chunkLine := uint16(0)
+	chunkLine := uint32(0)
+	// Nested .if blocks
+	conditions := []conditionalBlock{}
+
+	// Byte address of the next item in the current segment
+	location := func() uint32 {
+		if a.CurrentSegment != CodeSegment {
+			return a.SegmentLocation[a.CurrentSegment]
+		}
+		return startAddress + (chunkLine * 2)
+	}
 
-	simplelog.Info(fmt.Sprintf("Entering File %s at starting address 0x%04x", fn, startAddress/2))
-	for scanner.Scan() {
-		codeLine++
-		instruction, meta, err := parseLine(scanner.Text())
+	// Close the current section and lay data out at the current location
+	placeData := func(data []byte) error {
+		switch a.CurrentSegment {
+		case DataSegment:
+			return codeError(CodeMisplaced, "initialized data is not allowed in .dseg, reserve space with .byte")
+		case EEPROMSegment:
+			a.DbSections = append(a.DbSections, DataBlob{Data: data, Address: a.SegmentLocation[EEPROMSegment], Segment: EEPROMSegment, File: fn, Line: int(codeLine)})
+			a.SegmentLocation[EEPROMSegment] += uint32(len(data))
+			return nil
+		}
+		a.RawAssemblySections = append(a.RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions, File: fn})
+		instructions = []Instruction{}
+		startAddress = startAddress + (chunkLine * 2)
+		chunkLine = 0
+		// Pad odd sized data so the next instruction stays on a word boundary
+		if len(data)%2 != 0 {
+			data = append(slices.Clip(data), 0)
+		}
+		a.DbSections = append(a.DbSections, DataBlob{Data: data, Address: startAddress, File: fn, Line: int(codeLine)})
+		startAddress += uint32(len(data))
+		return nil
+	}
+
+	// Skip size bytes, SRAM is only reserved while other segments are filled
+	reserve := func(size uint32, fill byte) error {
+		if a.CurrentSegment == DataSegment {
+			a.SegmentLocation[DataSegment] += size
+			return nil
+		}
+		if size == 0 {
+			return nil
+		}
+		return placeData(bytes.Repeat([]byte{fill}, int(size)))
+	}
+
+	// Last label without a leading dot, .name labels belong to it
+	parent := ""
+
+	// Set after an unconditional jump or return, cleared by a label
+	afterJump := false
+	// Mnemonic placed last, a skip before a jump makes the jump conditional
+	lastMnemonic := ""
+
+	// Follow the flow through the next instruction, warning once when no label leads to it
+	placeCode := func(mnemonic string, what string) {
+		if afterJump {
+			a.report(SeverityWarning, CodeUnreachable, fn, int(codeLine), fmt.Sprintf("%s is never reached, it follows %s with no label in between", what, lastMnemonic))
+		}
+		afterJump = slices.Contains(FlowEndingInstructions, mnemonic) && !slices.Contains(SkipInstructions, lastMnemonic)
+		lastMnemonic = mnemonic
+	}
+
+	// Columns of the directive or instruction being assembled, for errors without their own
+	spanColumn, spanEnd := 0, 0
+
+	// Assemble the line just scanned, errors are reported and the next line read
+	assembleLine := func() error {
+		spanColumn, spanEnd = 0, 0
+		a.scope = labelScope{File: fn, Parent: parent}
+		listing := len(a.ListingLines)
+		a.ListingLines = append(a.ListingLines, ListingLine{File: fn, Line: int(codeLine), Depth: len(a.importStack) - 1, Text: scanner.Text(), Segment: a.CurrentSegment, Address: location()})
+		handled, inline, err := a.handleConditional(&conditions, scanner.Text())
+		if err != nil {
+			return err
+		}
+		if handled || !conditionsActive(conditions) {
+			return nil
+		}
+		text := scanner.Text()
+		if inline != "" {
+			text = inline
+		}
+		// Set when the line moves the location counter or places code listed elsewhere
+		moved, expanded := false, false
+		instruction, meta, err := a.parseLine(text, int(codeLine))
 		if err != nil {
-			return 0, fmt.Errorf("error in file %s on line %d, %s ", fn, codeLine, err)
+			return err
 		}
 		instruction.File = fn
-		instruction.Address = int(chunkLine + (startAddress / 2))
+		instruction.Address = chunkLine + (startAddress / 2)
 		instruction.Line = int(codeLine)
+		instruction.Text = scanner.Text()
 
 		for _, m := range meta {
+			spanColumn, spanEnd = m.Column, m.EndColumn
 			if m.Operation == "label" {
 				if inMacroDef != "" {
-					return 0, fmt.Errorf("labels cannot be created in macros")
+					return codeError(CodeMisplaced, "labels cannot be created in macros")
+				}
+				afterJump = false
+				local := strings.HasPrefix(m.Args, ".")
+				if !local {
+					parent = m.Args
+				}
+				key := a.definitionKey(m.Args)
+				a.scope.Parent = parent
+				if a.isLabel(key) {
+					// Keep the first definition, the rest of the line is still assembled
+					err = a.reportError(fn, int(codeLine), &DuplicateSymbolError{Span: Span{Column: m.Column, EndColumn: m.EndColumn}, Symbol: m.Args, Previous: a.SymbolDefinitions[key]})
+					if err != nil {
+						return err
+					}
+					continue
 				}
-				LabelMap[m.Args] = chunkLine + (startAddress / 2)
+				span := Span{File: fn, Line: int(codeLine), Column: m.Column, EndColumn: m.EndColumn}
+				if !local {
+					a.checkLabelName(m.Args, span)
+				}
+				if a.CurrentSegment != CodeSegment {
+					a.DataLabelMap[key] = DataLabel{Segment: a.CurrentSegment, Address: a.SegmentLocation[a.CurrentSegment]}
+				} else {
+					a.LabelMap[key] = chunkLine + (startAddress / 2)
+				}
+				a.SymbolDefinitions[key] = SourceLocation{File: fn, Line: int(codeLine)}
+				a.labelSpans[key] = span
+			}
+
+			if m.Operation == "global" {
+				for _, name := range strings.Split(m.Args, ",") {
+					err = a.exportLabel(name, fn)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if m.Operation == "extern" {
+				for n, name := range strings.Split(m.Args, ",") {
+					a.externs = append(a.externs, externDeclaration{Name: name, Span: Span{File: fn, Line: int(codeLine),
+						Column: m.ArgSpans[n].Column, EndColumn: m.ArgSpans[n].EndColumn}})
+				}
+			}
+
+			if m.Operation == "segment" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot switch segments inside macros")
+				}
+				a.CurrentSegment = Segment(slices.Index(SegmentNames, m.Args))
+				// SRAM reservations start after the register and I/O space
+				if _, ok := a.SegmentLocation[DataSegment]; !ok && a.CurrentSegment == DataSegment {
+					a.SegmentLocation[DataSegment] = 0x60
+					if a.TargetDevice != nil {
+						a.SegmentLocation[DataSegment] = a.TargetDevice.SRAMStart
+					}
+				}
+			}
+
+			if m.Operation == "byte" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot reserve bytes inside macros")
+				}
+				if a.CurrentSegment == CodeSegment {
+					return codeError(CodeMisplaced, ".byte reserves space in .dseg or .eseg, use .space in .cseg")
+				}
+				size, err := a.parseAddress(m.Args)
+				if err != nil {
+					return fmt.Errorf("error parsing size %s, %w", m.Args, err)
+				}
+				a.SegmentLocation[a.CurrentSegment] += size
 			}
 
 			if m.Operation == "org" {
 				if inMacroDef != "" {
-					return 0, fmt.Errorf("cannot define origin inside macros")
+					return codeError(CodeMisplaced, "cannot define origin inside macros")
+				}
+				moved = true
+				afterJump = false
+				if a.CurrentSegment != CodeSegment {
+					a.SegmentLocation[a.CurrentSegment], err = a.parseAddress(m.Args)
+					if err != nil {
+						return fmt.Errorf("error parsing address %s, %w", m.Args, err)
+					}
+					continue
 				}
-				RawAssemblySections = append(RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions})
+				a.RawAssemblySections = append(a.RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions, File: fn})
 				instructions = []Instruction{}
-				startAddress, err = parseImmidiateUints(m.Args)
+				startAddress, err = a.parseAddress(m.Args)
 				chunkLine = 0
 				if err != nil {
-					return 0, fmt.Errorf("error parsing address %s, %s ", m.Args, err)
+					return fmt.Errorf("error parsing address %s, %w", m.Args, err)
 				}
 				if (startAddress % 2) != 0 {
-					return 0, fmt.Errorf("address %s is not 16 bit aligned ", m.Args)
+					return codeError(CodeAlignment, "address %s is not 16 bit aligned", m.Args)
 				}
 			}
 
 			if m.Operation == "db" {
 				if inMacroDef != "" {
-					return 0, fmt.Errorf("cannot define data blob in macro")
+					return codeError(CodeMisplaced, "cannot define data blob in macro")
 				}
-				RawAssemblySections = append(RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions})
-				instructions = []Instruction{}
-				startAddress = startAddress + (chunkLine * 2)
-				chunkLine = 0
-
 				// Implementing strings only, more data later
 				data := []byte(m.Args)
 				data = append(data, byte(0))
-				entry := DataBlob{
-					Data:    data,
-					Address: startAddress + (chunkLine * 2),
+				if len(data)%2 != 0 && a.CurrentSegment == CodeSegment {
+					a.report(SeverityWarning, CodeOddData, fn, int(codeLine), fmt.Sprintf(".db has an odd number of bytes (%d), a zero byte is added to keep the next instruction aligned", len(data)))
+				}
+				err = placeData(data)
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "align" || m.Operation == "balign" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot align inside macros")
+				}
+				args := strings.Split(m.Args, ":")
+				boundary, err := a.parseImmidiateUints(args[0])
+				if err != nil {
+					return fmt.Errorf("error parsing alignment %s, %w", args[0], err)
+				}
+				if boundary == 0 || boundary&(boundary-1) != 0 {
+					return codeError(CodeAlignment, "alignment %d is not a power of two", boundary)
+				}
+				// .align counts words, .balign counts bytes
+				if m.Operation == "align" {
+					boundary *= 2
+				}
+				fill, err := a.parseFillValue(args, 1)
+				if err != nil {
+					return err
+				}
+				padding := (uint32(boundary) - (location() % uint32(boundary))) % uint32(boundary)
+				err = reserve(padding, fill)
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "space" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot reserve space inside macros")
+				}
+				args := strings.Split(m.Args, ":")
+				size, err := a.parseImmidiateUints(args[0])
+				if err != nil {
+					return fmt.Errorf("error parsing size %s, %w", args[0], err)
+				}
+				fill, err := a.parseFillValue(args, 1)
+				if err != nil {
+					return err
+				}
+				// Reserve whole words of flash so no unfilled byte is left behind
+				if a.CurrentSegment == CodeSegment {
+					size += size % 2
+				}
+				err = reserve(uint32(size), fill)
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "fill" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot fill inside macros")
+				}
+				args := strings.Split(m.Args, ":")
+				repeat, err := a.parseImmidiateUints(args[0])
+				if err != nil {
+					return fmt.Errorf("error parsing repeat count %s, %w", args[0], err)
+				}
+				size := uint16(1)
+				if len(args) > 1 {
+					size, err = a.parseImmidiateUints(args[1])
+					if err != nil {
+						return fmt.Errorf("error parsing fill size %s, %w", args[1], err)
+					}
+				}
+				if size != 1 && size != 2 && size != 4 {
+					return codeError(CodeValueRange, "fill size %d must be 1, 2 or 4 bytes", size)
+				}
+				value := uint16(0)
+				if len(args) > 2 {
+					value, err = a.parseImmidiateUints(args[2])
+					if err != nil {
+						return fmt.Errorf("error parsing fill value %s, %w", args[2], err)
+					}
+				}
+				// Values are stored little endian like the rest of flash
+				pattern := []byte{byte(value), byte(value >> 8), 0, 0}[:size]
+				data := bytes.Repeat(pattern, int(repeat))
+				if len(data)%2 != 0 && a.CurrentSegment == CodeSegment {
+					data = append(data, byte(value))
+				}
+				if len(data) > 0 {
+					err = placeData(data)
+					if err != nil {
+						return err
+					}
 				}
-				DbSections = append(DbSections, entry)
-				startAddress += uint16((len(data) % 2) + len(data))
 			}
 
 			if m.Operation == "macro" {
 				if inMacroDef != "" {
-					return 0, fmt.Errorf("cannot define macro inside another macro")
+					return codeError(CodeMisplaced, "cannot define macro inside another macro")
 				}
 				inMacroDef = m.Args
-				RawAssemblySections = append(RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions})
+				a.RawAssemblySections = append(a.RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions, File: fn})
 				instructions = []Instruction{}
 			}
 
 			if m.Operation == "endmacro" {
 				if inMacroDef == "" {
-					return 0, fmt.Errorf("no macro to complete")
+					return codeError(CodeMisplaced, "no macro to complete")
 				}
-				RawMacroSections[inMacroDef] = instructions
+				a.RawMacroSections[inMacroDef] = instructions
 				instructions = []Instruction{}
 				inMacroDef = ""
 			}
 
-			if m.Operation == "import" {
+			if m.Operation == "import" || m.Operation == "include" {
 				if inMacroDef != "" {
-					return 0, fmt.Errorf("cannot import inside macro definition")
+					return codeError(CodeMisplaced, "cannot %s inside macro definition", m.Operation)
+				}
+				importFileName, err := a.resolveSourcePath(m.Args, fn)
+				if err != nil {
+					return err
 				}
-				importFileName := m.Args
-				RawAssemblySections = append(RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions})
+				a.RawAssemblySections = append(a.RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions, File: fn})
 				instructions = []Instruction{}
-				startAddress, err = ParseFile(importFileName, uint16(startAddress+(chunkLine*2)))
+				expanded = true
+				afterJump = false
+				a.importStack[len(a.importStack)-1].Line = int(codeLine)
+				startAddress, err = a.ParseFile(importFileName, startAddress+(chunkLine*2))
+				a.scope = labelScope{File: fn, Parent: parent}
 				chunkLine = 0
 				if err != nil {
-					return 0, err
+					return err
+				}
+			}
+
+			if m.Operation == "device" {
+				err = a.SetDevice(m.Args)
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "fuse" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot set fuses inside macro definition")
+				}
+				name, expr, _ := strings.Cut(m.Args, ":")
+				err = a.setFuse(name, expr, fn, int(codeLine))
+				if err != nil {
+					return err
 				}
 			}
 
+			if m.Operation == "once" {
+				a.OnceFiles[canonical] = true
+			}
+
+			if m.Operation == "pragma" {
+				err = a.applyPragma(m.Args, fn, int(codeLine))
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "incbin" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot include binary inside macro definition")
+				}
+				args := strings.SplitN(m.Args, ":", 3)
+				binaryFileName, err := a.resolveSourcePath(args[2], fn)
+				if err != nil {
+					return err
+				}
+				data, err := a.readBinaryFile(binaryFileName, args[0], args[1])
+				if err != nil {
+					return err
+				}
+				// Pad to a word boundary so the next instruction stays aligned
+				if len(data)%2 != 0 && a.CurrentSegment == CodeSegment {
+					data = append(data, byte(0))
+				}
+				if len(data) > 0 {
+					err = placeData(data)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			if m.Operation == "error" || m.Operation == "warning" || m.Operation == "message" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot use .%s inside macro definition", m.Operation)
+				}
+				err = a.emitDiagnostic(m.Operation, m.Args, fn, int(codeLine))
+				if err != nil {
+					return err
+				}
+			}
+
+			if m.Operation == "assert" {
+				if inMacroDef != "" {
+					return codeError(CodeMisplaced, "cannot use .assert inside macro definition")
+				}
+				args := splitArguments(m.Args)
+				assertion := Assertion{Expression: args[0], File: fn, Line: int(codeLine), scope: a.scope}
+				if len(args) > 1 {
+					assertion.Message = parseMessage(args[1])
+				}
+				a.Assertions = append(a.Assertions, assertion)
+			}
+
 			if m.Operation == "invokeMacro" {
-				macroExpansion := RawMacroSections[m.Args]
+				if a.CurrentSegment != CodeSegment && inMacroDef == "" {
+					return codeError(CodeMisplaced, "macro %s can only be expanded in .cseg", m.Args)
+				}
+				macroExpansion := a.RawMacroSections[m.Args]
+				expanded = true
 				for _, instr := range macroExpansion {
-					instr.Address = int(startAddress + chunkLine)
+					// Labels in a macro are looked up where it is expanded
+					instr.scope = a.scope
+					if inMacroDef == "" {
+						placeCode(instr.Mnemonic, fmt.Sprintf("%s from macro %s", instr.Mnemonic, m.Args))
+					}
+					instr.Address = chunkLine + (startAddress / 2)
 					instructions = append(instructions, instr)
+					size := uint32(2)
 					chunkLine++
 					// 32bit istructions move the PC by 2
-					if slices.Contains(LongInstructions, instruction.Mnemonic) {
+					if slices.Contains(LongInstructions, instr.Mnemonic) {
 						chunkLine++
+						size += 2
+					}
+					if inMacroDef == "" {
+						a.ListingLines = append(a.ListingLines, ListingLine{File: instr.File, Line: instr.Line, Depth: len(a.importStack), Text: instr.Text, Segment: CodeSegment, Address: instr.Address * 2, Size: size, Placed: true})
 					}
 				}
 				continue
 			}
 
 			if m.Operation == "define" {
-				variableName := strings.Split(m.Args, ":")[0]
-
-				// Probably re-implement parse function to remove using label for variable (or maybe not could be useful for strings)
-				variableValue, err := parseImmidiateUints(strings.Split(m.Args, ":")[1])
+				variableName, expr, _ := strings.Cut(m.Args, ":")
+				variableValue, err := evalExpression(expr, a.lookupExpressionSymbol)
 				if err != nil {
-					return 0, err
+					return err
+				}
+				if key := a.findLabel(variableName); key != "" {
+					a.report(SeverityWarning, CodeShadowedName, fn, int(codeLine), fmt.Sprintf("constant %s has the name of a label, expressions will use the constant", variableName),
+						RelatedLocation{Message: fmt.Sprintf("label %s defined here", variableName), Source: a.SymbolDefinitions[key]})
 				}
-				VariableMapping[variableName] = variableValue
+				// Constants from Options.Defines have no definition to point at
+				if old, ok := a.VariableMapping[variableName]; ok && old != variableValue {
+					if defined, ok := a.SymbolDefinitions[variableName]; ok {
+						a.report(SeverityWarning, CodeRedefined, fn, int(codeLine), fmt.Sprintf("%s redefined from %d to %d", variableName, old, variableValue),
+							RelatedLocation{Message: fmt.Sprintf("%s first defined as %d here", variableName, old), Source: defined})
+					}
+				}
+				a.VariableMapping[variableName] = variableValue
+				a.SymbolDefinitions[variableName] = SourceLocation{File: fn, Line: int(codeLine)}
 			}
 		}
 
+		a.ListingLines[listing].Placed = inMacroDef == ""
+		if a.ListingLines[listing].Segment != a.CurrentSegment || moved {
+			a.ListingLines[listing].Segment = a.CurrentSegment
+			a.ListingLines[listing].Address = location()
+		} else if !expanded {
+			a.ListingLines[listing].Size = location() - a.ListingLines[listing].Address
+		}
+
 		// if white space, comment, or meta skip instruction logic
 		if instruction.Mnemonic == "" {
-			continue
+			return nil
+		}
+		spanColumn, spanEnd = instruction.Column, instruction.EndColumn
+		if a.CurrentSegment != CodeSegment && inMacroDef == "" {
+			return codeError(CodeMisplaced, "instruction %s can only be placed in .cseg", instruction.Mnemonic)
+		}
+		instruction.scope = a.scope
+		if inMacroDef == "" {
+			placeCode(instruction.Mnemonic, instruction.Mnemonic)
 		}
 		instructions = append(instructions, instruction)
 		simplelog.Trace(fmt.Sprintf("Parsing Instruction %s in file %s at line %d at address 0x%04x",
 			instruction.Mnemonic, fn, instruction.Line, instruction.Address))
 		if inMacroDef == "" {
 			chunkLine++
+			a.ListingLines[listing].Size += 2
 			// 32bit istructions move the PC by 2
 			if slices.Contains(LongInstructions, instruction.Mnemonic) {
 				chunkLine++
+				a.ListingLines[listing].Size += 2
 			}
 		}
+		return nil
 	}
 
-	if inMacroDef != "" {
-		return 0, fmt.Errorf("macro definition was never close")
+	simplelog.Info(fmt.Sprintf("Entering File %s at starting address 0x%04x", fn, startAddress/2))
+	for scanner.Scan() {
+		codeLine++
+		err := assembleLine()
+		if err != nil {
+			if column, _ := errorSpan(err); column == 0 && spanColumn > 0 {
+				err = &sourceError{Column: spanColumn, EndColumn: spanEnd, Err: err}
+			}
+			err = a.reportError(fn, int(codeLine), err)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return 0, err
+	}
 
+	if len(conditions) != 0 {
+		err = a.reportError(fn, 0, codeError(CodeUnterminatedBlock, ".if was never closed with .endif"))
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	RawAssemblySections = append(RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions})
-	return uint16(startAddress + (chunkLine * 2)), nil
+	if inMacroDef != "" {
+		err = a.reportError(fn, 0, codeError(CodeUnterminatedBlock, "macro definition %s was never closed with .endmacro", inMacroDef))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	a.RawAssemblySections = append(a.RawAssemblySections, AssemblySection{Address: startAddress, Assembly: instructions, File: fn})
+	return startAddress + (chunkLine * 2), nil
 }
 
-func parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
+func (a *Assembler) parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
+	// Errors point from the directive to the end of the line, the ones that
+	// don't say what went wrong are syntax errors
+	directive := 0
+	defer func() {
+		if err != nil {
+			column, endColumn := tokens[directive].Column, tokens[len(tokens)-1].EndColumn
+			if errorCode(err) == CodeError {
+				err = &SyntaxError{Span: Span{Column: column, EndColumn: endColumn}, Message: err.Error()}
+			} else {
+				err = &sourceError{Column: column, EndColumn: endColumn, Err: err}
+			}
+		}
+	}()
 	for i := 0; i < len(tokens); i++ {
+		directive = i
 		switch tokens[i].Type {
 		case "Label":
 			parsedTokens++
@@ -211,7 +695,7 @@ func parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
 			meta[i].Operation = "label"
 			meta[i].Args = tokens[i].Value[:len(tokens[i].Value)-1]
 		case "Operand":
-			macro, exists := isMacro(tokens[i].Value)
+			macro, exists := a.isMacro(tokens[i].Value)
 			if exists {
 				parsedTokens++
 				meta = append(meta, macro)
@@ -223,7 +707,7 @@ func parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
 			case ".db": // Use a offset for each db and then put it at end of code (maybe allow to be placed between orgs?)
 				parsedTokens++
 				meta[i].Operation = "db"
-				if len(tokens) < i+1 {
+				if len(tokens) <= i+1 {
 					return meta, 0, fmt.Errorf("no data provided")
 				}
 				meta[i].Args = tokens[i+1].Value
@@ -231,7 +715,7 @@ func parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
 			case ".org": // Set starting address for code after it
 				parsedTokens++
 				meta[i].Operation = "org"
-				if len(tokens) < i+1 {
+				if len(tokens) <= i+1 {
 					return meta, 0, fmt.Errorf("no origin provided")
 				}
 				if tokens[i+1].DataType != "Integer" {
@@ -257,39 +741,197 @@ func parseMeta(tokens []Token) (meta []Meta, parsedTokens int, err error) {
 				meta[i].Operation = "import"
 				meta[i].Args = tokens[i+1].Value
 				i++
-			case ".define":
-				parsedTokens += 2
-				if len(tokens) < i+1 {
+			case ".include", "#include": // avrasm2 and GNU style, "file" or <file>
+				parsedTokens++
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no file name provided")
+				}
+				meta[i].Operation = "include"
+				meta[i].Args = tokens[i+1].Value
+				if tokens[i+1].Type == "SystemPath" {
+					meta[i].Args = "<" + tokens[i+1].Value + ">"
+				}
+				i++
+			case ".once", "#pragma", ".pragma": // Only assemble this file the first time it is included, or switch warnings
+				if tokens[i].Value == ".once" {
+					meta[i].Operation = "once"
+					break
+				}
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no pragma given")
+				}
+				if strings.ToLower(tokens[i+1].Value) == "once" {
+					meta[i].Operation = "once"
+				} else {
+					args := []string{}
+					for _, t := range tokens[i+1:] {
+						args = append(args, t.Value)
+					}
+					meta[i].Operation = "pragma"
+					meta[i].Args = strings.Join(args, " ")
+				}
+				parsedTokens += len(tokens) - i - 1
+				i = len(tokens)
+			case ".incbin": // Raw bytes from a file, optionally sliced by offset and length
+				parsedTokens++
+				if len(tokens) <= i+1 || tokens[i+1].Type != "StringLiteral" {
+					return meta, 0, fmt.Errorf("no quoted file name provided")
+				}
+				meta[i].Operation = "incbin"
+				offset, length := "0", ""
+				if len(tokens) > i+2 {
+					offset = tokens[i+2].Value
+					parsedTokens++
+				}
+				if len(tokens) > i+3 {
+					length = tokens[i+3].Value
+					parsedTokens++
+				}
+				meta[i].Args = fmt.Sprintf("%s:%s:%s", offset, length, tokens[i+1].Value)
+				i = len(tokens)
+			case ".align", ".balign", ".space", ".fill": // Pad the location counter
+				meta[i].Operation = tokens[i].Value[1:]
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no size provided for %s", tokens[i].Value)
+				}
+				args := []string{}
+				for _, t := range tokens[i+1:] {
+					if t.Type != "Variable" && t.DataType != "Integer" {
+						return meta, 0, fmt.Errorf("argument %s for %s is not integer", t.Value, tokens[i].Value)
+					}
+					args = append(args, t.Value)
+				}
+				parsedTokens += len(args)
+				meta[i].Args = strings.Join(args, ":")
+				i += len(args)
+			case ".error", ".warning", ".message", ".assert": // Diagnostics from the source
+				meta[i].Operation = tokens[i].Value[1:]
+				if len(tokens) <= i+1 || tokens[i+1].Type != "Expression" {
+					if tokens[i].Value == ".assert" {
+						return meta, 0, fmt.Errorf("no condition given for .assert")
+					}
+				} else {
+					parsedTokens++
+					meta[i].Args = tokens[i+1].Value
+					i++
+				}
+			case ".fuse", ".lock": // Fuse and lock bytes, .fuse low = CKSEL_INT8MHZ & SUT_65MS
+				if len(tokens) <= i+1 || tokens[i+1].Type != "Expression" {
+					return meta, 0, fmt.Errorf("no value given for %s", tokens[i].Value)
+				}
+				parsedTokens++
+				name, expr := "lock", tokens[i+1].Value
+				if tokens[i].Value == ".fuse" {
+					var found bool
+					name, expr, found = strings.Cut(expr, "=")
+					if !found {
+						return meta, 0, fmt.Errorf(".fuse needs a fuse name and value, .fuse low = 0xe2")
+					}
+				}
+				meta[i].Operation = "fuse"
+				meta[i].Args = fmt.Sprintf("%s:%s", strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(expr), "=")))
+				i++
+			case ".cseg", ".dseg", ".eseg": // Switch between flash, SRAM and EEPROM
+				meta[i].Operation = "segment"
+				meta[i].Args = tokens[i].Value
+			case ".section": // GNU spelling of the segment directives
+				parsedTokens++
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no section name provided")
+				}
+				// The tokenizer drops the leading dot of the section name
+				name := "." + strings.TrimPrefix(tokens[i+1].Value, ".")
+				index := slices.Index(SectionNames, name)
+				if index < 0 {
+					return meta, 0, fmt.Errorf("unknown section %s, expected one of %s", name, strings.Join(SectionNames, ", "))
+				}
+				meta[i].Operation = "segment"
+				meta[i].Args = SegmentNames[index]
+				i++
+			case ".byte": // Reserve bytes in .dseg or .eseg
+				parsedTokens++
+				if len(tokens) <= i+1 || tokens[i+1].DataType != "Integer" {
+					return meta, 0, fmt.Errorf("no size provided for .byte")
+				}
+				meta[i].Operation = "byte"
+				meta[i].Args = tokens[i+1].Value
+				i++
+			case ".global", ".globl", ".extern": // Export labels of this file, or declare labels another file exports
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no label given for %s", tokens[i].Value)
+				}
+				names := []string{}
+				for _, t := range tokens[i+1:] {
+					if t.Type != "Operand" {
+						return meta, 0, fmt.Errorf("%s is not a label name", t.Value)
+					}
+					names = append(names, t.Value)
+					meta[i].ArgSpans = append(meta[i].ArgSpans, Span{Column: t.Column, EndColumn: t.EndColumn})
+				}
+				meta[i].Operation = "global"
+				if tokens[i].Value == ".extern" {
+					meta[i].Operation = "extern"
+				}
+				meta[i].Args = strings.Join(names, ",")
+				parsedTokens += len(names)
+				i += len(names)
+			case ".device": // Select the target microcontroller
+				parsedTokens++
+				if len(tokens) <= i+1 {
+					return meta, 0, fmt.Errorf("no device name provided")
+				}
+				meta[i].Operation = "device"
+				meta[i].Args = tokens[i+1].Value
+				i++
+			case ".define", ".equ": // NAME value or NAME = value, the value is an expression
+				if len(tokens) <= i+1 {
 					return meta, 0, fmt.Errorf("no variable name given")
 				}
-				if len(tokens) < i+2 {
-					return meta, 0, fmt.Errorf("no value given for %s", tokens[i+1].Value)
+				parsedTokens++
+				expr := tokens[i+1].Value
+				nameEnd := strings.IndexFunc(expr, func(r rune) bool { return unicode.IsSpace(r) || r == '=' })
+				if nameEnd < 0 {
+					nameEnd = len(expr)
+				}
+				name := expr[:nameEnd]
+				if name == "" {
+					return meta, 0, syntaxErrorAt(tokens[i+1].Column, tokens[i+1].EndColumn, "no name given before the value of %s", tokens[i].Value)
+				}
+				value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(expr[nameEnd:]), "="))
+				if value == "" {
+					return meta, 0, fmt.Errorf("no value given for %s", name)
 				}
 				meta[i].Operation = "define"
-				meta[i].Args = fmt.Sprintf("%s:%s", tokens[i+1].Value, tokens[i+2].Value)
-				i += 2
+				meta[i].Args = fmt.Sprintf("%s:%s", name, value)
+				i++
 			}
 		}
+		if len(meta) > directive {
+			meta[directive].Column = tokens[directive].Column
+			meta[directive].EndColumn = tokens[min(i, len(tokens)-1)].EndColumn
+		}
 	}
 	return meta, parsedTokens, nil
 }
 
 type Token struct {
-	Type     string
-	Value    string
-	DataType string // Add data type
-	Line     int
-	Column   int
+	Type      string
+	Value     string
+	DataType  string // Add data type
+	Line      int
+	Column    int // First column of the token, starting at 1
+	EndColumn int // Column after the token
 }
 
-func tokenizeLine(code string) (tokens []Token, err error) {
+// Split a source line into tokens, line is only recorded in the tokens
+func tokenizeLine(code string, line int) (tokens []Token, err error) {
 	tokens = []Token{}
-	line := 0
 	for i := 0; i < len(code); i++ {
 		r := rune(code[i])
 		if unicode.IsSpace(r) {
 			continue
 		}
+		start := i + 1
 
 		if r == ';' {
 			// Comment detected
@@ -297,12 +939,39 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 			i = len(code)
 		}
 
-		if r == '.' && len(tokens) == 0 {
+		// Directives may follow labels on the same line
+		if (r == '.' || r == '#') && (len(tokens) == 0 || tokens[len(tokens)-1].Type == "Label") {
 			buf := ""
 			for ; i < len(code) && !unicode.IsSpace(rune(code[i])); i++ {
 				buf += string(code[i])
 			}
-			tokens = append(tokens, Token{Type: "MetaTag", Value: buf, DataType: "String", Line: line, Column: i})
+			// .name: is a label local to the label before it
+			if len(buf) > 2 && strings.HasSuffix(buf, ":") && r == '.' {
+				tokens = append(tokens, Token{Type: "Label", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
+				continue
+			}
+			tokens = append(tokens, Token{Type: "MetaTag", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
+			// Expression directives keep the rest of the line verbatim
+			if slices.Contains(ExpressionDirectives, strings.ToLower(buf)) {
+				rest := stripComment(code[i:])
+				expr := strings.TrimSpace(rest)
+				if expr != "" {
+					column := i + 1 + len(rest) - len(strings.TrimLeftFunc(rest, unicode.IsSpace))
+					tokens = append(tokens, Token{Type: "Expression", Value: expr, DataType: "String", Line: line, Column: column, EndColumn: column + len(expr)})
+				}
+				i = len(code)
+			}
+			continue
+		}
+
+		// Operand naming a .name local label
+		if r == '.' && i+1 < len(code) && unicode.IsLetter(rune(code[i+1])) {
+			buf := ""
+			for ; i < len(code) && (!unicode.IsSpace(rune(code[i]))) && (code[i] != ','); i++ {
+				buf += string(code[i])
+			}
+			tokens = append(tokens, Token{Type: "Operand", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
+			continue
 		}
 
 		if unicode.IsLetter(r) {
@@ -311,10 +980,21 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 				buf += string(code[i])
 			}
 			if buf[len(buf)-1] != ':' {
-				tokens = append(tokens, Token{Type: "Operand", Value: buf, DataType: "String", Line: line, Column: i})
+				tokens = append(tokens, Token{Type: "Operand", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
 			} else {
-				tokens = append(tokens, Token{Type: "Label", Value: buf, DataType: "String", Line: line, Column: i})
+				tokens = append(tokens, Token{Type: "Label", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
+			}
+		}
+
+		if r == '<' && len(tokens) == 1 && tokens[0].Type == "MetaTag" {
+			// Search path only include such as #include <m328Pdef.inc>
+			end := strings.IndexByte(code[i:], '>')
+			if end < 0 {
+				return tokens, syntaxErrorAt(start, len(code)+1, "found < without matching >")
 			}
+			tokens = append(tokens, Token{Type: "SystemPath", Value: code[i+1 : i+end], DataType: "String", Line: line, Column: start, EndColumn: i + end + 2})
+			i += end
+			continue
 		}
 
 		if r == '$' {
@@ -325,11 +1005,11 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 					break
 				}
 				if strings.ContainsAny(string(code[i]), ".,?/\\[]{}()*&^%$#@!<>-:;\"'") {
-					return tokens, fmt.Errorf("special character found in variable name")
+					return tokens, syntaxErrorAt(i+1, i+2, "special character found in variable name")
 				}
 				buf += string(code[i])
 			}
-			tokens = append(tokens, Token{Type: "Variable", Value: buf, DataType: "String", Line: line, Column: i})
+			tokens = append(tokens, Token{Type: "Variable", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 1})
 		}
 
 		if r == '"' {
@@ -338,8 +1018,8 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 			i++
 			for ; i < len(code) && code[i] != '"'; i++ {
 				if code[i] == '\\' {
-					if len(code) < i+1 {
-						return tokens, fmt.Errorf("unfinished escape on line %d", line)
+					if len(code) <= i+1 {
+						return tokens, syntaxErrorAt(i+1, i+2, "unfinished escape")
 					}
 					switch code[i+1] {
 					case 't':
@@ -351,7 +1031,7 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 					case '\\':
 						buf += "\\"
 					default:
-						return tokens, fmt.Errorf("unrecognized exscaped char on line %d", line)
+						return tokens, syntaxErrorAt(i+1, i+3, "unrecognized escaped char")
 					}
 					i++
 				} else {
@@ -359,12 +1039,12 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 				}
 			}
 
-			if code[len(code)-1] != '"' {
-				return tokens, fmt.Errorf("found string without matching \" on line %d col %d", line, i)
+			if i >= len(code) {
+				return tokens, syntaxErrorAt(start, i+1, "found string without matching \"")
 			}
 
 			if i < len(code) && code[i] == '"' {
-				tokens = append(tokens, Token{Type: "StringLiteral", Value: buf, DataType: "String", Line: line, Column: i})
+				tokens = append(tokens, Token{Type: "StringLiteral", Value: buf, DataType: "String", Line: line, Column: start, EndColumn: i + 2})
 				i++ // Advance past the closing quote
 			}
 		}
@@ -372,8 +1052,17 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 		if r == '0' {
 			buf := ""
 			tokenType := ""
-			if len(code) <= i+1 && unicode.IsLetter(rune(code[i+1])) {
-				return tokens, fmt.Errorf("incomplete number on line %d col %d", line, i)
+			if len(code) <= i+1 || !unicode.IsLetter(rune(code[i+1])) {
+				tokenType = "Decimal"
+				for ; i < len(code); i++ {
+					if unicode.IsSpace(rune(code[i])) || rune(code[i]) == ',' || rune(code[i]) == ' ' || rune(code[i]) == ';' {
+						break
+					}
+					if !unicode.IsDigit(rune(code[i])) {
+						return tokens, syntaxErrorAt(i+1, i+2, "non-decimal digit")
+					}
+					buf += string(code[i])
+				}
 			} else if code[i+1] == 'x' {
 				tokenType = "Hexidecimal"
 				i = i + 2
@@ -383,7 +1072,7 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 						break
 					}
 					if !unicode.IsDigit(rune(code[i])) && !strings.ContainsAny(strings.ToUpper(string(code[i])), "A | B | C | D | E | F") {
-						return tokens, fmt.Errorf("non-hex char [%s] on line %d col %d", string(code[i]), line, i)
+						return tokens, syntaxErrorAt(i+1, i+2, "non-hex char [%s]", string(code[i]))
 					}
 					buf += string(code[i])
 				}
@@ -396,24 +1085,14 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 						break
 					}
 					if !strings.ContainsAny(strings.ToUpper(string(code[i])), "1 | 0") {
-						return tokens, fmt.Errorf("non-binary char on line %d col %d", line, i)
+						return tokens, syntaxErrorAt(i+1, i+2, "non-binary char")
 					}
 					buf += string(code[i])
 				}
 			} else {
-				tokenType = "Decimal"
-				buf := ""
-				for ; i < len(code); i++ {
-					if unicode.IsSpace(rune(code[i])) || rune(code[i]) == ',' || rune(code[i]) == ' ' || rune(code[i]) == ';' {
-						break
-					}
-					if !unicode.IsDigit(rune(code[i])) {
-						return tokens, fmt.Errorf("non-decimal digit on line %d col %d", line, i)
-					}
-					buf += string(code[i])
-				}
+				return tokens, syntaxErrorAt(start, i+3, "incomplete number")
 			}
-			tokens = append(tokens, Token{Type: tokenType, Value: buf, DataType: "Integer", Line: line, Column: i})
+			tokens = append(tokens, Token{Type: tokenType, Value: buf, DataType: "Integer", Line: line, Column: start, EndColumn: i + 1})
 
 		} else if unicode.IsDigit(rune(r)) {
 			// Integer Detection:  Very basic - needs refinement
@@ -421,15 +1100,15 @@ func tokenizeLine(code string) (tokens []Token, err error) {
 			for ; i < len(code) && unicode.IsDigit(rune(code[i])); i++ {
 				buf += string(code[i])
 			}
-			tokens = append(tokens, Token{Type: "Decimal", Value: buf, DataType: "Integer", Line: line, Column: i})
+			tokens = append(tokens, Token{Type: "Decimal", Value: buf, DataType: "Integer", Line: line, Column: start, EndColumn: i + 1})
 		}
 	}
 	return tokens, nil
 }
 
-func parseLine(line string) (Instruction, []Meta, error) {
+func (a *Assembler) parseLine(line string, lineNumber int) (Instruction, []Meta, error) {
 	// Remove comments and trim whitespace
-	tokens, err := tokenizeLine(line)
+	tokens, err := tokenizeLine(line, lineNumber)
 	if err != nil {
 		return Instruction{}, []Meta{}, err
 	}
@@ -437,7 +1116,7 @@ func parseLine(line string) (Instruction, []Meta, error) {
 		return Instruction{}, []Meta{}, nil
 	}
 
-	meta, parsedTokens, err := parseMeta(tokens)
+	meta, parsedTokens, err := a.parseMeta(tokens)
 	if err != nil {
 		return Instruction{}, []Meta{}, err
 	}
@@ -456,51 +1135,134 @@ func parseLine(line string) (Instruction, []Meta, error) {
 	}
 
 	return Instruction{
-		Mnemonic: mnemonic,
-		Operands: operands,
+		Mnemonic:  mnemonic,
+		Operands:  operands,
+		Column:    instructionTokens[0].Column,
+		EndColumn: instructionTokens[0].EndColumn,
 	}, meta, nil
 }
 
-type ParserFunc func(args []string, line_addr int) ([2]uint16, error)
+// Operand parser, a method expression such as (*Assembler).parseTwoRegs
+type ParserFunc func(a *Assembler, args []string, line_addr int) ([2]uint16, error)
 
 var InstructionParse = map[string]ParserFunc{
 	// Arithmetic and Logic Instructions
-	"ADC":  parseTwoRegs,
-	"ADD":  parseTwoRegs,
-	"AND":  parseTwoRegs,
-	"ANDI": parseRegImm,
+	"ADC":  (*Assembler).parseTwoRegs,
+	"ADD":  (*Assembler).parseTwoRegs,
+	"AND":  (*Assembler).parseTwoRegs,
+	"ANDI": (*Assembler).parseRegImm,
 	//ADIW
-	"COM": parseOneReg,
-	"DEC": parseOneReg,
-	"SUB": parseTwoRegs,
+	"COM": (*Assembler).parseOneReg,
+	"DEC": (*Assembler).parseOneReg,
+	"SUB": (*Assembler).parseTwoRegs,
 	//SUBI
-	"OR":    parseTwoRegs,
-	"ORI":   parseRegImm,
-	"SBC":   parseTwoRegs,
-	"SBIS":  parseSkipBit,
-	"LDI":   parseRegImm,
-	"IN":    parseIOpsIn,
-	"OUT":   parseIOpsOut,
-	"CPI":   parseRegImm,
-	"POP":   parseOneReg,
-	"PUSH":  parseOneReg,
-	"BRBC":  pasrseBranchSreg,
-	"BRNE":  pasrseBranchStaticSreg,
-	"BREQ":  pasrseBranchStaticSreg,
-	"BRBS":  pasrseBranchSreg,
-	"RJMP":  parseRelBranch,
-	"RCALL": parseRelBranch,
-	"RET":   parseConst,
-	"LPM":   parseLPM,
-	"LDS":   parseLDS,
-	"STS":   parseSTS,
-	"ELPM":  parseELPM,
-	"NOP":   parseConst,
-	"TST":   parseTST,
+	"OR":    (*Assembler).parseTwoRegs,
+	"ORI":   (*Assembler).parseRegImm,
+	"SBC":   (*Assembler).parseTwoRegs,
+	"SBIS":  (*Assembler).parseSkipBit,
+	"LDI":   (*Assembler).parseRegImm,
+	"IN":    (*Assembler).parseIOpsIn,
+	"OUT":   (*Assembler).parseIOpsOut,
+	"CPI":   (*Assembler).parseRegImm,
+	"POP":   (*Assembler).parseOneReg,
+	"PUSH":  (*Assembler).parseOneReg,
+	"BRBC":  (*Assembler).pasrseBranchSreg,
+	"BRNE":  (*Assembler).pasrseBranchStaticSreg,
+	"BREQ":  (*Assembler).pasrseBranchStaticSreg,
+	"BRBS":  (*Assembler).pasrseBranchSreg,
+	"RJMP":  (*Assembler).parseRelBranch,
+	"RCALL": (*Assembler).parseRelBranch,
+	"RET":   (*Assembler).parseConst,
+	"LPM":   (*Assembler).parseLPM,
+	"LDS":   (*Assembler).parseLDS,
+	"STS":   (*Assembler).parseSTS,
+	"ELPM":  (*Assembler).parseELPM,
+	"NOP":   (*Assembler).parseConst,
+	"TST":   (*Assembler).parseTST,
 }
 
 // Helper Functions
 
+// Resolve a source path relative to the including file, then through IncludePaths,
+// IncludeRoot and finally the working directory (the root of FS). <file> skips
+// the including file
+func (a *Assembler) resolveSourcePath(name string, includingFile string) (string, error) {
+	searchPaths := []string{}
+	if strings.HasPrefix(name, "<") && strings.HasSuffix(name, ">") {
+		name = name[1 : len(name)-1]
+	} else {
+		searchPaths = append(searchPaths, a.sourceDir(includingFile))
+	}
+	if a.isAbsSourcePath(name) {
+		return name, nil
+	}
+	searchPaths = append(searchPaths, a.IncludePaths...)
+	if a.IncludeRoot != "" {
+		searchPaths = append(searchPaths, a.IncludeRoot)
+	}
+	for _, dir := range searchPaths {
+		candidate := a.joinSourcePath(dir, name)
+		if _, err := fs.Stat(a.FS, candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	if _, err := fs.Stat(a.FS, name); err == nil {
+		return name, nil
+	}
+	return "", codeError(CodeFileNotFound, "file %s not found in %s", name, strings.Join(append(searchPaths, "."), ", "))
+}
+
+// Read a binary file, limited to length bytes from offset when given
+func (a *Assembler) readBinaryFile(fn string, offsetArg string, lengthArg string) (data []byte, err error) {
+	data, err = fs.ReadFile(a.FS, fn)
+	if err != nil {
+		return nil, &sourceError{Code: CodeFileNotFound, Err: err}
+	}
+	offset, err := a.parseAddress(offsetArg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing offset %s, %w", offsetArg, err)
+	}
+	if int(offset) > len(data) {
+		return nil, codeError(CodeValueRange, "offset %d is past the end of %s (%d bytes)", offset, fn, len(data))
+	}
+	data = data[offset:]
+	if lengthArg != "" {
+		length, err := a.parseAddress(lengthArg)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing length %s, %w", lengthArg, err)
+		}
+		if int(length) > len(data) {
+			return nil, codeError(CodeValueRange, "length %d from offset %d is past the end of %s", length, offset, fn)
+		}
+		data = data[:length]
+	}
+	return data, nil
+}
+
+// Optional fill byte for padding directives, defaults to 0x00 (NOP in code)
+func (a *Assembler) parseFillValue(args []string, index int) (fill byte, err error) {
+	if len(args) <= index {
+		return 0, nil
+	}
+	value, err := a.parseImmidiateUints(args[index])
+	if err != nil {
+		return 0, fmt.Errorf("error parsing fill value %s, %w", args[index], err)
+	}
+	if value > 0xff {
+		return 0, rangeError("fill value", int64(value), 0, 0xff)
+	}
+	return byte(value), nil
+}
+
+// Whether name is spelled like a register, r0 to r31 or X, Y and Z
+func isRegisterName(name string) bool {
+	if slices.Contains([]string{"X", "Y", "Z"}, strings.ToUpper(name)) {
+		return true
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(name), "r"), 10, 8)
+	return err == nil && len(name) > 1 && strings.ToLower(name[:1]) == "r" && number <= 31
+}
+
 func parsePointerRegisters(reg_str string) (reg_uint uint16, ok bool, err error) {
 	reg_parts := strings.Split(reg_str, "(")
 	reg_letter := reg_parts[0]
@@ -519,22 +1281,21 @@ func parsePointerRegisters(reg_str string) (reg_uint uint16, ok bool, err error)
 			reg_uint += 1
 		} else if reg_parts[1] == "LOW)" {
 		} else {
-			return 0, false, fmt.Errorf("unknown suffix (%s", reg_parts[1])
+			return 0, false, syntaxError("unknown suffix (%s", reg_parts[1])
 		}
 	}
 	return reg_uint, true, nil
 }
 
-func parseImmidiateUints(num string) (imm uint16, err error) {
+func (a *Assembler) parseImmidiateUints(num string) (imm uint16, err error) {
 	im, err := strconv.ParseUint(num, 10, 16)
 	if err == nil {
 		return uint16(im), nil
 	} else if num[0] == '$' {
-		variable, ok := VariableMapping[num[1:]]
-		if !ok {
-			return 0, fmt.Errorf("%s not defined", num[1:])
+		if _, ok := a.VariableMapping[num[1:]]; !ok {
+			return 0, &UndefinedSymbolError{Symbol: num[1:], Kind: "variable", Suggestion: a.suggestSymbol(num[1:], "variable")}
 		}
-		return variable, nil
+		return a.constantWord(num[1:])
 	} else if num[0:2] == "0b" {
 		imm, err := strconv.ParseUint(num[2:], 2, 16)
 		if err != nil {
@@ -547,13 +1308,23 @@ func parseImmidiateUints(num string) (imm uint16, err error) {
 			return 0, err
 		}
 		if imm > 65535 {
-			return 0, fmt.Errorf("value %d larger than 16bits(65535)", imm)
+			return 0, rangeError("value", int64(imm), 0, 0xffff)
 		}
 		return uint16(imm), nil
 	} else {
 		labelParsed := strings.Split(num, "(")
-		imm, err := getLabelAddress(labelParsed[0])
-		imm *= 2 // Addresses are stored at half the physical memory address (Probaly need to fix this)
+		// Constants such as I/O registers from a device definition file come before labels, as in expressions
+		if _, ok := a.VariableMapping[labelParsed[0]]; ok && len(labelParsed) == 1 {
+			return a.constantWord(labelParsed[0])
+		}
+		if a.findLabel(labelParsed[0]) == "" {
+			return 0, &UndefinedSymbolError{Symbol: labelParsed[0], Kind: "symbol", Suggestion: a.suggestSymbol(labelParsed[0], "symbol"), LocalTo: a.hiddenLabel(labelParsed[0])}
+		}
+		if dataLabel, ok := a.DataLabelMap[a.findLabel(labelParsed[0])]; ok && len(labelParsed) == 1 {
+			a.referencedSymbols[a.findLabel(labelParsed[0])] = true
+			return uint16(dataLabel.Address), nil
+		}
+		imm, err := a.getLabelByteAddress(labelParsed[0])
 		if err != nil {
 			return 0, err
 		} else {
@@ -564,13 +1335,41 @@ func parseImmidiateUints(num string) (imm uint16, err error) {
 				} else if strings.Split(labelParsed[1], ")")[0] == "LOW" {
 					imm &= 0x00ff
 				} else {
-					return 0, fmt.Errorf("unknown suffix (%s", labelParsed[1])
+					return 0, syntaxError("unknown suffix (%s", labelParsed[1])
 				}
 				return uint16(imm), nil
 			}
 		}
-		return 0, fmt.Errorf(" unable to parse [%s] indwto uint", num)
+		return 0, syntaxError(" unable to parse [%s] indwto uint", num)
+	}
+}
+
+// Constant as an operand word, negative constants are taken as two's complement
+func (a *Assembler) constantWord(name string) (uint16, error) {
+	value := a.VariableMapping[name]
+	if value < -0x8000 || value > 0xffff {
+		return 0, rangeError("value of "+name, value, -0x8000, 0xffff)
 	}
+	return uint16(value), nil
+}
+
+// Parse a flash or data address, which may be wider than 16 bits
+func (a *Assembler) parseAddress(num string) (addr uint32, err error) {
+	if num[0] == '$' {
+		imm, err := a.parseImmidiateUints(num)
+		return uint32(imm), err
+	}
+	base, digits := 10, num
+	if strings.HasPrefix(num, "0x") {
+		base, digits = 16, num[2:]
+	} else if strings.HasPrefix(num, "0b") {
+		base, digits = 2, num[2:]
+	}
+	value, err := strconv.ParseUint(digits, base, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(value), nil
 }
 
 func parseRegister5bits(reg_str string) (reg_uint uint16, err error) {
@@ -581,28 +1380,28 @@ func parseRegister5bits(reg_str string) (reg_uint uint16, err error) {
 		return uint16(reg_uint), nil
 	}
 	if strings.ToUpper(reg_str[0:1]) != "R" {
-		return 0, fmt.Errorf(" argument [%s] is not regiter rXX", reg_str)
+		return 0, codeError(CodeRegisterRange, " argument [%s] is not regiter rXX", reg_str)
 	}
 	reg_num, err := strconv.ParseUint(reg_str[1:], 10, 16)
 	if err != nil {
 		return 0, err
 	}
 	if reg_num > 31 {
-		return 0, fmt.Errorf(" register [%s] does not exist", reg_str)
+		return 0, rangeError("register", int64(reg_num), 0, 31)
 	}
 	return uint16(reg_num), nil
 }
 
 func parseRegister4bits(reg_str string) (reg_uint uint16, err error) {
 	if strings.ToUpper(reg_str[0:1]) != "R" {
-		return 0, fmt.Errorf(" argument [%s] is not regiter rXX", reg_str)
+		return 0, codeError(CodeRegisterRange, " argument [%s] is not regiter rXX", reg_str)
 	}
 	reg_num, err := strconv.ParseUint(reg_str[1:], 10, 16)
 	if err != nil {
 		return 0, err
 	}
 	if reg_num > 31 || reg_num < 16 {
-		return 0, fmt.Errorf(" register [%s] does not exist", reg_str)
+		return 0, rangeError("register", int64(reg_num), 16, 31)
 	}
 	return uint16(reg_num), nil
 }
@@ -618,7 +1417,7 @@ func parsePointerRegister(reg_str string) (reg PointerRegister, post_inc bool, e
 	case "Z":
 		reg = Z
 	default:
-		err = fmt.Errorf(" argument [%s] is not X, Y or Z", reg_str)
+		err = codeError(CodeRegisterRange, " argument [%s] is not X, Y or Z", reg_str)
 	}
 
 	if strings.Contains(reg_str, "+") {
@@ -630,47 +1429,59 @@ func parsePointerRegister(reg_str string) (reg PointerRegister, post_inc bool, e
 
 // Arg Parser
 
-func getLabelAddress(label string) (addr uint16, err error) {
-	addr, ok := LabelMap[label]
+// Byte address of a label, code labels are stored as word addresses
+func (a *Assembler) getLabelByteAddress(label string) (addr uint32, err error) {
+	if dataLabel, ok := a.DataLabelMap[a.findLabel(label)]; ok {
+		a.referencedSymbols[a.findLabel(label)] = true
+		return dataLabel.Address, nil
+	}
+	addr, err = a.getLabelAddress(label)
+	return addr * 2, err
+}
+
+func (a *Assembler) getLabelAddress(label string) (addr uint32, err error) {
+	key := a.findLabel(label)
+	addr, ok := a.LabelMap[key]
 	if !ok {
 		// panic("FUCK")
-		return 0, fmt.Errorf("label [%s] not found", label)
+		return 0, &UndefinedSymbolError{Symbol: label, Kind: "label", Suggestion: a.suggestSymbol(label, "label"), LocalTo: a.hiddenLabel(label)}
 	}
+	a.referencedSymbols[key] = true
 	return addr, nil
 }
 
-func parseConst(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseConst(args []string, line_addr int) (ops [2]uint16, err error) {
 	return [2]uint16{0, 0}, nil
 }
 
-func parseSkipBit(args []string, line_addr int) (ops [2]uint16, err error) {
-	ops[0], err = parseImmidiateUints(args[0])
+func (a *Assembler) parseSkipBit(args []string, line_addr int) (ops [2]uint16, err error) {
+	ops[0], err = a.parseImmidiateUints(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[0] > 31 {
-		return [2]uint16{0, 0}, fmt.Errorf("uint value [%d] is not a valid flag [0-31]", ops[0])
+		return [2]uint16{0, 0}, rangeError("I/O address", int64(ops[0]), 0, 31)
 	}
 
-	ops[0], err = parseImmidiateUints(args[1])
+	ops[0], err = a.parseImmidiateUints(args[1])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[0] > 7 {
-		return [2]uint16{0, 0}, fmt.Errorf("uint value [%d] is not a valid flag [0-7]", ops[0])
+		return [2]uint16{0, 0}, rangeError("bit", int64(ops[0]), 0, 7)
 	}
 	return ops, nil
 }
 
-func pasrseBranchStaticSreg(args []string, line_addr int) (ops [2]uint16, err error) {
-	label_addr, err := getLabelAddress(args[0])
+func (a *Assembler) pasrseBranchStaticSreg(args []string, line_addr int) (ops [2]uint16, err error) {
+	label_addr, err := a.getLabelAddress(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 
 	rel_addr := int(label_addr) - line_addr - 1
 	if rel_addr > 2047 || rel_addr < -2048 {
-		return [2]uint16{0, 0}, fmt.Errorf("relative address [%d] is not in range of +/- 2k", rel_addr)
+		return [2]uint16{0, 0}, rangeError("relative address", int64(rel_addr), -2048, 2047)
 	}
 
 	ops[0] = uint16(0)
@@ -679,68 +1490,68 @@ func pasrseBranchStaticSreg(args []string, line_addr int) (ops [2]uint16, err er
 	return ops, nil
 }
 
-func pasrseBranchSreg(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) pasrseBranchSreg(args []string, line_addr int) (ops [2]uint16, err error) {
 
-	ops[0], err = parseImmidiateUints(args[0])
+	ops[0], err = a.parseImmidiateUints(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[0] > 7 {
-		return [2]uint16{0, 0}, fmt.Errorf("uint value [%d] is not a valid flag [0-7]", ops[0])
+		return [2]uint16{0, 0}, rangeError("bit", int64(ops[0]), 0, 7)
 	}
 
-	label_addr, err := getLabelAddress(args[0])
+	label_addr, err := a.getLabelAddress(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 
 	rel_addr := int(label_addr) - line_addr - 1
 	if rel_addr > 2047 || rel_addr < -2048 {
-		return [2]uint16{0, 0}, fmt.Errorf("relative address [%d] is not in range of +/- 2k", rel_addr)
+		return [2]uint16{0, 0}, rangeError("relative address", int64(rel_addr), -2048, 2047)
 	}
 	ops[1] = uint16(rel_addr)
 
 	return ops, nil
 }
 
-func parseRelBranch(args []string, line_addr int) (ops [2]uint16, err error) {
-	label_addr, err := getLabelAddress(args[0])
+func (a *Assembler) parseRelBranch(args []string, line_addr int) (ops [2]uint16, err error) {
+	label_addr, err := a.getLabelAddress(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 
 	rel_addr := int(label_addr) - line_addr - 1
 	if rel_addr > 2047 || rel_addr < -2048 {
-		return [2]uint16{0, 0}, fmt.Errorf("relative address [%d] is not in range of +/- 2k", rel_addr)
+		return [2]uint16{0, 0}, rangeError("relative address", int64(rel_addr), -2048, 2047)
 	}
 	ops[0] = uint16(rel_addr) & 0x0fff
 	return ops, nil
 }
 
-func parseIOpsIn(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseIOpsIn(args []string, line_addr int) (ops [2]uint16, err error) {
 	ops[0], err = parseRegister5bits(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 
-	ops[1], err = parseImmidiateUints(args[1])
+	ops[1], err = a.parseImmidiateUints(args[1])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[1] > 63 {
-		return [2]uint16{0, 0}, fmt.Errorf(" invalid io space [%d] > 63", ops[1])
+		return [2]uint16{0, 0}, rangeError("I/O address", int64(ops[1]), 0, 63)
 	}
 	return ops, nil
 }
 
-func parseIOpsOut(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseIOpsOut(args []string, line_addr int) (ops [2]uint16, err error) {
 
-	ops[0], err = parseImmidiateUints(args[0])
+	ops[0], err = a.parseImmidiateUints(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[0] > 63 {
-		return [2]uint16{0, 0}, fmt.Errorf(" invalid io space [%d] > 63", ops[1])
+		return [2]uint16{0, 0}, rangeError("I/O address", int64(ops[0]), 0, 63)
 	}
 
 	ops[1], err = parseRegister5bits(args[1])
@@ -750,7 +1561,7 @@ func parseIOpsOut(args []string, line_addr int) (ops [2]uint16, err error) {
 	return ops, nil
 }
 
-func parseOneReg(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseOneReg(args []string, line_addr int) (ops [2]uint16, err error) {
 
 	ops[0], err = parseRegister5bits(args[0])
 	if err != nil {
@@ -761,7 +1572,7 @@ func parseOneReg(args []string, line_addr int) (ops [2]uint16, err error) {
 	return ops, nil
 }
 
-func parseTwoRegs(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseTwoRegs(args []string, line_addr int) (ops [2]uint16, err error) {
 
 	ops[0], err = parseRegister5bits(args[0])
 	if err != nil {
@@ -775,38 +1586,38 @@ func parseTwoRegs(args []string, line_addr int) (ops [2]uint16, err error) {
 	return ops, nil
 }
 
-func parseRegImm(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseRegImm(args []string, line_addr int) (ops [2]uint16, err error) {
 
 	ops[0], err = parseRegister4bits(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	if ops[0] < 16 || ops[0] > 31 {
-		return [2]uint16{0, 0}, fmt.Errorf(" register r%d is not 16 ≤ Rd ≤ 31", ops[0])
+		return [2]uint16{0, 0}, rangeError("register", int64(ops[0]), 16, 31)
 	}
 	ops[0] = ops[0] - 16
 
-	ops[1], err = parseImmidiateUints(args[1])
+	ops[1], err = a.parseImmidiateUints(args[1])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	return ops, nil
 }
 
-func parseLDS(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseLDS(args []string, line_addr int) (ops [2]uint16, err error) {
 	ops[0], err = parseRegister5bits(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
-	ops[1], err = parseImmidiateUints(args[1])
+	ops[1], err = a.parseImmidiateUints(args[1])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
 	return ops, nil
 }
 
-func parseSTS(args []string, line_addr int) (ops [2]uint16, err error) {
-	ops[0], err = parseImmidiateUints(args[0])
+func (a *Assembler) parseSTS(args []string, line_addr int) (ops [2]uint16, err error) {
+	ops[0], err = a.parseImmidiateUints(args[0])
 	if err != nil {
 		return [2]uint16{0, 0}, err
 	}
@@ -818,10 +1629,10 @@ func parseSTS(args []string, line_addr int) (ops [2]uint16, err error) {
 	return ops, nil
 }
 
-func parseLPM(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseLPM(args []string, line_addr int) (ops [2]uint16, err error) {
 	// no arguments provided, this should be in zero-operand form
 	if len(args) == 0 {
-		ops, err = parseConst(args, line_addr)
+		ops, err = a.parseConst(args, line_addr)
 		// each encoder function takes 3 arguments, but I need 5
 		// pieces of information for this, so we're gonna encode
 		// it into the 3rd argument, ops[1] (aka zqi)
@@ -847,7 +1658,7 @@ func parseLPM(args []string, line_addr int) (ops [2]uint16, err error) {
 	//			 I've only seen examples of Z in the docs, so it's
 	//			 unclear whether X or Y are allowed here...
 	if ptr_reg != Z {
-		err = fmt.Errorf("pointer register value must be Z or Z+")
+		err = codeError(CodeRegisterRange, "pointer register value must be Z or Z+")
 	}
 
 	// set i bit to 1
@@ -861,8 +1672,8 @@ func parseLPM(args []string, line_addr int) (ops [2]uint16, err error) {
 // these parsing functions never receive information about the actual instruction
 // ELPM needs its own call, and it should reference the LPM parser but set the q bit
 // LPM/ELPM can share an encoder though
-func parseELPM(args []string, line_addr int) (ops [2]uint16, err error) {
-	ops, err = parseLPM(args, line_addr)
+func (a *Assembler) parseELPM(args []string, line_addr int) (ops [2]uint16, err error) {
+	ops, err = a.parseLPM(args, line_addr)
 
 	// set the q bit (zqi)
 	ops[1] |= 0b010
@@ -870,7 +1681,7 @@ func parseELPM(args []string, line_addr int) (ops [2]uint16, err error) {
 	return
 }
 
-func parseTST(args []string, line_addr int) (ops [2]uint16, err error) {
+func (a *Assembler) parseTST(args []string, line_addr int) (ops [2]uint16, err error) {
 
 	ops[0], err = parseRegister5bits(args[0])
 	if err != nil {
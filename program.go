@@ -0,0 +1,257 @@
+package avrassembler
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Label or constant of an assembled program
+type Symbol struct {
+	Name     string
+	Value    uint32 // Byte address in Segment, or the value of a constant
+	Segment  Segment
+	Constant bool   // Set with .define, .equ or Options.Defines
+	File     string // File a label is local to, empty for .global labels
+	Defined  SourceLocation
+}
+
+// Encoded instruction and the source line it came from
+type InstructionRecord struct {
+	Address  uint32 // Byte address in flash
+	Words    []uint16
+	Mnemonic string
+	Operands []string
+	Source   SourceLocation
+}
+
+// Placed code or data, addresses in bytes
+type Section struct {
+	Segment Segment
+	Kind    string // code or data
+	Start   uint32
+	End     uint32
+	File    string
+}
+
+// Encoded program with everything the output writers need
+type Program struct {
+	Device       *Device // nil when no device was selected
+	Flash        *MemoryImage
+	EEPROM       *MemoryImage
+	DataStart    uint32 // SRAM reserved with .dseg spans DataStart to DataEnd
+	DataEnd      uint32
+	Sections     []Section           // By segment and address
+	Symbols      []Symbol            // By segment and address, constants last
+	Instructions []InstructionRecord // By address
+	Listing      []ListingLine       // Source lines in assembly order
+	Files        []string            // Source files, the main file first
+	Fuses        map[string]byte     // Fuse and lock values keyed by FuseByte name
+	Diagnostics  []Diagnostic
+}
+
+// Labels and constants sorted by segment and address, constants last
+func (a *Assembler) collectSymbols() []Symbol {
+	symbols := []Symbol{}
+	for key, addr := range a.LabelMap {
+		file, name := splitLabelKey(key)
+		symbols = append(symbols, Symbol{Name: name, Value: addr * 2, Segment: CodeSegment, File: file, Defined: a.SymbolDefinitions[key]})
+	}
+	for key, label := range a.DataLabelMap {
+		file, name := splitLabelKey(key)
+		symbols = append(symbols, Symbol{Name: name, Value: label.Address, Segment: label.Segment, File: file, Defined: a.SymbolDefinitions[key]})
+	}
+	for name, value := range a.VariableMapping {
+		symbols = append(symbols, Symbol{Name: name, Value: uint32(value), Constant: true, Defined: a.SymbolDefinitions[name]})
+	}
+	slices.SortFunc(symbols, func(x, y Symbol) int {
+		if x.Constant != y.Constant {
+			if x.Constant {
+				return 1
+			}
+			return -1
+		}
+		if x.Segment != y.Segment {
+			return cmp.Compare(x.Segment, y.Segment)
+		}
+		if x.Value != y.Value {
+			return cmp.Compare(x.Value, y.Value)
+		}
+		if x.Name != y.Name {
+			return strings.Compare(x.Name, y.Name)
+		}
+		return strings.Compare(x.File, y.File)
+	})
+	return symbols
+}
+
+// Sections from RawAssemblySections and DbSections in address order. Empty
+// assembly sections left behind by .org, .macro and .include are skipped
+func (a *Assembler) collectSections() []Section {
+	sections := []Section{}
+	for _, section := range a.RawAssemblySections {
+		if len(section.Assembly) == 0 {
+			continue
+		}
+		start, end := section.Assembly[0].Address*2, uint32(0)
+		for _, instruction := range section.Assembly {
+			start = min(start, instruction.Address*2)
+			end = max(end, instruction.Address*2+instructionSize(instruction))
+		}
+		sections = append(sections, Section{Segment: CodeSegment, Kind: "code", Start: start, End: end, File: section.File})
+	}
+	for _, blob := range a.DbSections {
+		end := blob.Address + uint32(len(blob.Data))
+		sections = append(sections, Section{Segment: blob.Segment, Kind: "data", Start: blob.Address, End: end, File: blob.File})
+	}
+	slices.SortStableFunc(sections, func(x, y Section) int {
+		if x.Segment != y.Segment {
+			return cmp.Compare(x.Segment, y.Segment)
+		}
+		return cmp.Compare(x.Start, y.Start)
+	})
+	return sections
+}
+
+// Gather the encoded images and everything the writers need into a Program
+func (a *Assembler) buildProgram(flash *MemoryImage, instructions []InstructionRecord, eeprom *MemoryImage) *Program {
+	dataStart := uint32(0x60)
+	if a.TargetDevice != nil {
+		dataStart = a.TargetDevice.SRAMStart
+	}
+	dataEnd := max(a.SegmentLocation[DataSegment], dataStart)
+	slices.SortStableFunc(instructions, func(x, y InstructionRecord) int {
+		return cmp.Compare(x.Address, y.Address)
+	})
+	fuses := map[string]byte{}
+	for name, value := range a.FuseValues {
+		fuses[name] = value
+	}
+	return &Program{
+		Device:       a.TargetDevice,
+		Flash:        flash,
+		EEPROM:       eeprom,
+		DataStart:    dataStart,
+		DataEnd:      dataEnd,
+		Sections:     a.collectSections(),
+		Symbols:      a.collectSymbols(),
+		Instructions: instructions,
+		Listing:      slices.Clone(a.ListingLines),
+		Files:        slices.Clone(a.SourceFiles),
+		Fuses:        fuses,
+		Diagnostics:  slices.Clone(a.diagnostics),
+	}
+}
+
+// Symbol by name, a .global label before a file-local one of the same name
+func (p *Program) Symbol(name string) (Symbol, bool) {
+	found, ok := Symbol{}, false
+	for _, symbol := range p.Symbols {
+		if symbol.Name != name {
+			continue
+		}
+		if symbol.File == "" {
+			return symbol, true
+		}
+		if !ok {
+			found, ok = symbol, true
+		}
+	}
+	return found, ok
+}
+
+// Byte address of a code label or a number such as 0x1f000
+func (p *Program) EntryAddress(entry string) (uint32, error) {
+	if symbol, ok := p.Symbol(entry); ok && !symbol.Constant && symbol.Segment == CodeSegment {
+		return symbol.Value, nil
+	}
+	addr, err := strconv.ParseUint(entry, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("entry point %s is not a label or address", entry)
+	}
+	return uint32(addr), nil
+}
+
+// Flash as Intel HEX, entry adds a start linear address record when not nil
+func (p *Program) WriteHex(w io.Writer, recordLength int, entry *uint32) error {
+	hex, err := toIntelHex(p.Flash, recordLength, entry)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, hex)
+	return err
+}
+
+// EEPROM contents as Intel HEX, the .eep file avrdude expects
+func (p *Program) WriteEEPROMHex(w io.Writer, recordLength int) error {
+	hex, err := toIntelHex(p.EEPROM, recordLength, nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, hex)
+	return err
+}
+
+// Flash as Motorola S-records with header in the S0 record
+func (p *Program) WriteSRecord(w io.Writer, recordLength int, header string, entry *uint32) error {
+	srec, err := toSRecord(p.Flash, recordLength, header, entry)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, srec)
+	return err
+}
+
+// Flash as a flat binary from base, padded with 0xFF to padTo when it is not 0
+func (p *Program) WriteBinary(w io.Writer, base uint32, padTo uint32) error {
+	binary, err := toBinary(p.Flash, base, padTo)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(binary)
+	return err
+}
+
+// ELF executable with memory, fuse and debug sections and a symbol table
+func (p *Program) WriteELF(w io.Writer, entry uint32) error {
+	sections, err := p.elfMemorySections()
+	if err != nil {
+		return err
+	}
+	sections = append(sections, p.elfFuseSections()...)
+	sections = append(sections, p.elfDebugSections()...)
+	architecture := uint32(defaultELFArchitecture)
+	if p.Device != nil {
+		architecture = p.Device.Architecture
+	}
+	elfFile, err := toELF(sections, p.elfSymbols(), entry, architecture)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(elfFile)
+	return err
+}
+
+// Raw fuse bytes, low fuse first
+func (p *Program) WriteFuses(w io.Writer) error {
+	if p.Device == nil {
+		return fmt.Errorf("fuses need a device, use -d or .device")
+	}
+	_, err := w.Write(p.fuseBytes())
+	return err
+}
+
+// Listing with addresses, encoded words and source followed by a cross-reference
+func (p *Program) WriteListing(w io.Writer) error {
+	_, err := io.WriteString(w, p.toListing())
+	return err
+}
+
+// Map with section placement, symbols and memory usage
+func (p *Program) WriteMap(w io.Writer) error {
+	_, err := io.WriteString(w, p.toMapFile())
+	return err
+}
@@ -0,0 +1,166 @@
+package avrassembler
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+)
+
+// Location in the JSON diagnostics output, columns are 0 when unknown
+type jsonLocation struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+}
+
+type jsonRelated struct {
+	Message  string        `json:"message"`
+	Location *jsonLocation `json:"location,omitempty"`
+}
+
+type jsonDiagnostic struct {
+	Code       string        `json:"code"`
+	Title      string        `json:"title"`
+	Severity   string        `json:"severity"`
+	Category   string        `json:"category,omitempty"`
+	Message    string        `json:"message"`
+	Location   jsonLocation  `json:"location"`
+	Related    []jsonRelated `json:"related,omitempty"`
+	Suggestion string        `json:"suggestion,omitempty"`
+}
+
+// Diagnostics as a JSON document, {"diagnostics": [...]}
+func WriteDiagnosticsJSON(w io.Writer, diagnostics []Diagnostic) error {
+	out := struct {
+		Diagnostics []jsonDiagnostic `json:"diagnostics"`
+	}{Diagnostics: []jsonDiagnostic{}}
+	for _, d := range diagnostics {
+		entry := jsonDiagnostic{
+			Code:       d.Code,
+			Title:      CodeTitle(d.Code),
+			Severity:   d.Severity.String(),
+			Category:   d.Category,
+			Message:    d.Message,
+			Location:   jsonLocation{File: d.Source.File, Line: d.Source.Line, Column: d.Column, EndColumn: d.EndColumn},
+			Suggestion: d.Suggestion,
+		}
+		for _, related := range d.Related {
+			note := jsonRelated{Message: related.Message}
+			if related.Source.File != "" {
+				note.Location = &jsonLocation{File: related.Source.File, Line: related.Source.Line}
+			}
+			entry.Related = append(entry.Related, note)
+		}
+		out.Diagnostics = append(out.Diagnostics, entry)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// SARIF 2.1.0 types, only the parts code scanning tools read
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               *int                   `json:"id,omitempty"`
+	Message          *sarifMessage          `json:"message,omitempty"`
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+// SARIF levels by Severity
+var sarifLevels = []string{"error", "warning", "note"}
+
+func sarifPhysical(source SourceLocation, column int, endColumn int) *sarifPhysicalLocation {
+	location := &sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(source.File)}}
+	// Problems with a whole file or program have no region
+	if source.Line > 0 {
+		location.Region = &sarifRegion{StartLine: source.Line, StartColumn: column, EndColumn: endColumn}
+	}
+	return location
+}
+
+// Diagnostics as a SARIF 2.1.0 log for code scanning and pull request annotations
+func WriteDiagnosticsSARIF(w io.Writer, diagnostics []Diagnostic) error {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: "avrassembler", Rules: []sarifRule{}}}, Results: []sarifResult{}}
+	codes := []string{}
+	for _, d := range diagnostics {
+		if !slices.Contains(codes, d.Code) {
+			codes = append(codes, d.Code)
+		}
+		result := sarifResult{
+			RuleID:    d.Code,
+			Level:     sarifLevels[d.Severity],
+			Message:   sarifMessage{Text: d.Message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysical(d.Source, d.Column, d.EndColumn)}},
+		}
+		for i, related := range d.Related {
+			id := i
+			location := sarifLocation{ID: &id, Message: &sarifMessage{Text: related.Message}}
+			// Notes such as did you mean suggestions have no place in the source
+			if related.Source.File != "" {
+				location.PhysicalLocation = sarifPhysical(related.Source, 0, 0)
+			}
+			result.RelatedLocations = append(result.RelatedLocations, location)
+		}
+		run.Results = append(run.Results, result)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: code, ShortDescription: sarifMessage{Text: CodeTitle(code)}})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{Schema: "https://json.schemastore.org/sarif-2.1.0.json", Version: "2.1.0", Runs: []sarifRun{run}})
+}
@@ -0,0 +1,225 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+// A shadow warning with a span, an error with a related location and one
+// with a suggestion
+const diagnosticsSource = `.equ LIMIT = 10
+r5: ldi r16, LIMT
+start: nop
+start: nop
+`
+
+const diagnosticsJSON = `{
+  "diagnostics": [
+    {
+      "code": "W0004",
+      "title": "label shadows a register, instruction or constant",
+      "severity": "warning",
+      "category": "shadow",
+      "message": "label r5 has the name of a register",
+      "location": {
+        "file": "main.S",
+        "line": 2,
+        "column": 1,
+        "endColumn": 4
+      }
+    },
+    {
+      "code": "E0106",
+      "title": "duplicate label",
+      "severity": "error",
+      "message": "label start is already defined at main.S:3",
+      "location": {
+        "file": "main.S",
+        "line": 4,
+        "column": 1,
+        "endColumn": 7
+      },
+      "related": [
+        {
+          "message": "start first defined here",
+          "location": {
+            "file": "main.S",
+            "line": 3
+          }
+        }
+      ]
+    },
+    {
+      "code": "E0104",
+      "title": "undefined symbol",
+      "severity": "error",
+      "message": "symbol [LIMT] not found",
+      "location": {
+        "file": "main.S",
+        "line": 2,
+        "column": 14,
+        "endColumn": 18
+      },
+      "related": [
+        {
+          "message": "did you mean LIMIT?"
+        }
+      ],
+      "suggestion": "LIMIT"
+    }
+  ]
+}
+`
+
+const diagnosticsSARIF = `{
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "version": "2.1.0",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "avrassembler",
+          "rules": [
+            {
+              "id": "E0104",
+              "shortDescription": {
+                "text": "undefined symbol"
+              }
+            },
+            {
+              "id": "E0106",
+              "shortDescription": {
+                "text": "duplicate label"
+              }
+            },
+            {
+              "id": "W0004",
+              "shortDescription": {
+                "text": "label shadows a register, instruction or constant"
+              }
+            }
+          ]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "W0004",
+          "level": "warning",
+          "message": {
+            "text": "label r5 has the name of a register"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "main.S"
+                },
+                "region": {
+                  "startLine": 2,
+                  "startColumn": 1,
+                  "endColumn": 4
+                }
+              }
+            }
+          ]
+        },
+        {
+          "ruleId": "E0106",
+          "level": "error",
+          "message": {
+            "text": "label start is already defined at main.S:3"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "main.S"
+                },
+                "region": {
+                  "startLine": 4,
+                  "startColumn": 1,
+                  "endColumn": 7
+                }
+              }
+            }
+          ],
+          "relatedLocations": [
+            {
+              "id": 0,
+              "message": {
+                "text": "start first defined here"
+              },
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "main.S"
+                },
+                "region": {
+                  "startLine": 3
+                }
+              }
+            }
+          ]
+        },
+        {
+          "ruleId": "E0104",
+          "level": "error",
+          "message": {
+            "text": "symbol [LIMT] not found"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "main.S"
+                },
+                "region": {
+                  "startLine": 2,
+                  "startColumn": 14,
+                  "endColumn": 18
+                }
+              }
+            }
+          ],
+          "relatedLocations": [
+            {
+              "id": 0,
+              "message": {
+                "text": "did you mean LIMIT?"
+              }
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}
+`
+
+func TestDiagnosticsOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w io.Writer, diagnostics []avrassembler.Diagnostic) error
+		want  string
+	}{
+		{name: "json", write: avrassembler.WriteDiagnosticsJSON, want: diagnosticsJSON},
+		{name: "sarif", write: avrassembler.WriteDiagnosticsSARIF, want: diagnosticsSARIF},
+	}
+	asm, _, err := assemble(t, avrassembler.Options{}, fstest.MapFS{"main.S": {Data: []byte(diagnosticsSource)}})
+	if err == nil {
+		t.Fatal("assembled, want the undefined and duplicate symbol errors")
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		err := tt.write(out, asm.Diagnostics())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s output is\n%s\nwant\n%s", tt.name, out, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package avrassembler
+
+import (
+	"slices"
+	"strings"
+)
+
+// Where label names are looked up from. Labels are local to the file defining
+// them unless exported with .global, and .name labels belong to the last label
+// without a dot before them
+type labelScope struct {
+	File   string
+	Parent string // Last label without a leading dot, empty before the first one
+}
+
+// .extern line, checked once every file is parsed
+type externDeclaration struct {
+	Span // Where the name is written
+	Name string
+}
+
+// LabelMap and DataLabelMap key of a label local to file, .global labels are
+// keyed by their name alone
+func fileLabelKey(file string, name string) string {
+	return file + ":" + name
+}
+
+// Name and file of a label key, file is empty for .global labels
+func splitLabelKey(key string) (file string, name string) {
+	index := strings.LastIndex(key, ":")
+	if index < 0 {
+		return "", key
+	}
+	return key[:index], key[index+1:]
+}
+
+func (a *Assembler) isLabel(key string) bool {
+	_, isCode := a.LabelMap[key]
+	_, isData := a.DataLabelMap[key]
+	return isCode || isData
+}
+
+// Key a label defined in the current scope is stored under
+func (a *Assembler) definitionKey(name string) string {
+	if strings.HasPrefix(name, ".") {
+		return fileLabelKey(a.scope.File, a.scope.Parent+name)
+	}
+	if a.exports[fileLabelKey(a.scope.File, name)] {
+		return name
+	}
+	return fileLabelKey(a.scope.File, name)
+}
+
+// Key of the label name refers to in the current scope, a label of the same
+// file before a .global one. Empty when there is none
+func (a *Assembler) findLabel(name string) string {
+	local := strings.HasPrefix(name, ".")
+	if local {
+		name = a.scope.Parent + name
+	}
+	if key := fileLabelKey(a.scope.File, name); a.isLabel(key) {
+		return key
+	}
+	if !local && a.isLabel(name) {
+		return name
+	}
+	return ""
+}
+
+// Definition of a label called name that is local to another file, for the
+// hint that it needs .global. Labels of the current file are already visible
+func (a *Assembler) hiddenLabel(name string) SourceLocation {
+	if strings.HasPrefix(name, ".") {
+		return SourceLocation{}
+	}
+	keys := []string{}
+	for key := range a.SymbolDefinitions {
+		if file, label := splitLabelKey(key); file != "" && file != a.scope.File && label == name && a.isLabel(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return SourceLocation{}
+	}
+	slices.Sort(keys)
+	return a.SymbolDefinitions[keys[0]]
+}
+
+// Names of the labels visible from the current scope, for suggestions
+func (a *Assembler) visibleLabels() []string {
+	names := []string{}
+	add := func(key string) {
+		file, name := splitLabelKey(key)
+		if file != "" && file != a.scope.File {
+			return
+		}
+		if local, ok := strings.CutPrefix(name, a.scope.Parent+"."); ok && a.scope.Parent != "" {
+			name = "." + local
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for key := range a.LabelMap {
+		add(key)
+	}
+	for key := range a.DataLabelMap {
+		add(key)
+	}
+	return names
+}
+
+// Export a label of fn with .global, it may already be defined in fn
+func (a *Assembler) exportLabel(name string, fn string) error {
+	if strings.HasPrefix(name, ".") {
+		return syntaxError("local label %s cannot be exported with .global", name)
+	}
+	local := fileLabelKey(fn, name)
+	a.exports[local] = true
+	if !a.isLabel(local) {
+		return nil
+	}
+	if a.isLabel(name) {
+		return &DuplicateSymbolError{Symbol: name, Previous: a.SymbolDefinitions[name]}
+	}
+	if addr, ok := a.LabelMap[local]; ok {
+		a.LabelMap[name] = addr
+		delete(a.LabelMap, local)
+	}
+	if label, ok := a.DataLabelMap[local]; ok {
+		a.DataLabelMap[name] = label
+		delete(a.DataLabelMap, local)
+	}
+	a.SymbolDefinitions[name] = a.SymbolDefinitions[local]
+	delete(a.SymbolDefinitions, local)
+	a.labelSpans[name] = a.labelSpans[local]
+	delete(a.labelSpans, local)
+	if a.referencedSymbols[local] {
+		a.referencedSymbols[name] = true
+		delete(a.referencedSymbols, local)
+	}
+	return nil
+}
+
+// Report .extern labels that no file exported with .global
+func (a *Assembler) checkExterns() error {
+	for _, extern := range a.externs {
+		if a.isLabel(extern.Name) {
+			continue
+		}
+		a.scope = labelScope{File: extern.File}
+		err := a.reportError(extern.File, extern.Line, &UndefinedSymbolError{
+			Span:       extern.Span,
+			Symbol:     extern.Name,
+			Kind:       "global label",
+			Suggestion: closestMatch(extern.Name, a.globalLabels()),
+			LocalTo:    a.hiddenLabel(extern.Name),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Assembler) globalLabels() []string {
+	names := []string{}
+	for key := range a.SymbolDefinitions {
+		if !strings.Contains(key, ":") && a.isLabel(key) {
+			names = append(names, key)
+		}
+	}
+	return names
+}
@@ -0,0 +1,188 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+func TestLabelScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  []byte
+	}{
+		{
+			name: "each file has its own labels",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("loop: rjmp loop\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte("loop: rjmp loop\n")},
+			},
+			want: []byte{0xff, 0xcf, 0xff, 0xcf},
+		},
+		{
+			name: ".global labels are seen from every file",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("rcall delay\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte(".global delay\ndelay: ret\n")},
+			},
+			want: []byte{0x00, 0xd0, 0x08, 0x95},
+		},
+		{
+			name: ".extern names a label another file exports",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte(".extern delay\nrcall delay\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte("delay: ret\n.global delay\n")},
+			},
+			want: []byte{0x00, 0xd0, 0x08, 0x95},
+		},
+		{
+			name: "a label of the same file wins over a .global one",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("rjmp delay\ndelay: nop\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte(".global delay\ndelay: ret\n")},
+			},
+			want: []byte{0x00, 0xc0, 0x00, 0x00, 0x08, 0x95},
+		},
+		{
+			name: ".local labels belong to the label before them",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("first: nop\n.loop: rjmp .loop\nsecond: rjmp .loop\n.loop: rjmp first.loop\n")},
+			},
+			want: []byte{0x00, 0x00, 0xff, 0xcf, 0x00, 0xc0, 0xfd, 0xcf},
+		},
+	}
+	for _, tt := range tests {
+		_, program, err := assemble(t, avrassembler.Options{}, tt.files)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := program.Flash.Read(0, uint32(program.Flash.Len())); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: flash holds % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLabelScopeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		code    string
+		related []string // Messages of the notes
+	}{
+		{
+			name: "label local to another file",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("rcall helper\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte("helper: ret\n")},
+			},
+			code:    avrassembler.CodeUndefinedSymbol,
+			related: []string{"helper is local to a.inc, export it with .global helper"},
+		},
+		{
+			// A label of the file with the .extern line is visible already, no .global hint
+			name: ".extern of a label of the same file",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte(".extern helper\nhelper: ret\n")},
+			},
+			code:    avrassembler.CodeUndefinedSymbol,
+			related: []string{},
+		},
+		{
+			name: ".extern of a label local to another file",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte(".extern helper\nnop\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte("helper: ret\n")},
+			},
+			code:    avrassembler.CodeUndefinedSymbol,
+			related: []string{"helper is local to a.inc, export it with .global helper"},
+		},
+		{
+			name: "local label of another parent",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("first: nop\n.loop: nop\nsecond: rjmp .lop\n")},
+			},
+			code:    avrassembler.CodeUndefinedSymbol,
+			related: []string{},
+		},
+		{
+			name: ".global in two files",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte(".global delay\ndelay: ret\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte(".global delay\ndelay: ret\n")},
+			},
+			code:    avrassembler.CodeDuplicateSymbol,
+			related: []string{"delay first defined here"},
+		},
+		{
+			name: ".global of a .local label",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("first: nop\n.loop: nop\n.global .loop\n")},
+			},
+			code:    avrassembler.CodeSyntax,
+			related: []string{},
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := assemble(t, avrassembler.Options{}, tt.files)
+		var list avrassembler.ErrorList
+		if !errors.As(err, &list) || len(list) != 1 {
+			t.Errorf("%s: got %v, want one %s error", tt.name, err, tt.code)
+			continue
+		}
+		related := []string{}
+		for _, r := range list[0].Related {
+			related = append(related, r.Message)
+		}
+		if list[0].Code != tt.code || !slices.Equal(related, tt.related) {
+			t.Errorf("%s: got %s with notes %q, want %s with notes %q", tt.name, list[0].Code, related, tt.code, tt.related)
+		}
+	}
+}
+
+// Suggestions come from the labels visible where the name is used
+func TestLabelScopeSuggestions(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{
+			name:  "local label under the same parent",
+			files: fstest.MapFS{"main.S": {Data: []byte("first: nop\n.loop: rjmp .lop\n")}},
+			want:  ".loop",
+		},
+		{
+			name: "labels local to another file are not suggested",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("rcall helpr\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte("helper: ret\n")},
+			},
+			want: "",
+		},
+		{
+			name: ".global labels are suggested",
+			files: fstest.MapFS{
+				"main.S": {Data: []byte("rcall helpr\n.include \"a.inc\"\n")},
+				"a.inc":  {Data: []byte(".global helper\nhelper: ret\n")},
+			},
+			want: "helper",
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := assemble(t, avrassembler.Options{}, tt.files)
+		var list avrassembler.ErrorList
+		if !errors.As(err, &list) || len(list) != 1 {
+			t.Errorf("%s: got %v, want one undefined label error", tt.name, err)
+			continue
+		}
+		if list[0].Suggestion != tt.want {
+			t.Errorf("%s: suggested %q, want %q", tt.name, list[0].Suggestion, tt.want)
+		}
+	}
+}
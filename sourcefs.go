@@ -0,0 +1,55 @@
+package avrassembler
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// OS filesystem used when Options.FS is nil. Unlike os.DirFS it takes absolute
+// and ../ paths as they are written in .include lines or on the command line
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// Key telling two spellings of the same source file apart, absolute on the OS
+// filesystem and a cleaned slash path inside any other fs.FS
+func (a *Assembler) canonicalPath(fn string) (string, error) {
+	if _, ok := a.FS.(osFS); ok {
+		return filepath.Abs(fn)
+	}
+	return path.Clean(filepath.ToSlash(fn)), nil
+}
+
+// Directory of a source path, slash separated inside an fs.FS on every OS
+func (a *Assembler) sourceDir(fn string) string {
+	if _, ok := a.FS.(osFS); ok {
+		return filepath.Dir(fn)
+	}
+	return path.Dir(filepath.ToSlash(fn))
+}
+
+func (a *Assembler) joinSourcePath(dir string, name string) string {
+	if _, ok := a.FS.(osFS); ok {
+		return filepath.Join(dir, name)
+	}
+	return path.Join(filepath.ToSlash(dir), filepath.ToSlash(name))
+}
+
+func (a *Assembler) isAbsSourcePath(fn string) bool {
+	if _, ok := a.FS.(osFS); ok {
+		return filepath.IsAbs(fn)
+	}
+	return path.IsAbs(filepath.ToSlash(fn))
+}
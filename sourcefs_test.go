@@ -0,0 +1,83 @@
+package avrassembler_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+func TestSourcesFromFS(t *testing.T) {
+	files := fstest.MapFS{
+		"src/main.S": {Data: []byte(`.include "config.inc"
+.include "util.inc"
+.incbin "blob.bin", 1, 2
+.include "sub/deep.inc"
+`)},
+		// Next to the including file
+		"src/config.inc": {Data: []byte("ldi r16, 1\n")},
+		// Found through IncludePaths
+		"lib/util.inc": {Data: []byte("ldi r17, 2\n")},
+		"src/blob.bin": {Data: []byte{0x11, 0xaa, 0xbb, 0x22}},
+		// Relative to sub/, not to src/
+		"src/sub/deep.inc": {Data: []byte(`.include "leaf.inc"` + "\n")},
+		"src/sub/leaf.inc": {Data: []byte("ldi r18, 3\n")},
+	}
+	asm, err := avrassembler.NewAssembler(avrassembler.Options{FS: files, IncludePaths: []string{"lib"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm.AddFile("src/main.S")
+	program, err := asm.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0x01, 0xe0, 0x12, 0xe0, 0xaa, 0xbb, 0x23, 0xe0}
+	if got := program.Flash.Read(0, uint32(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("flash holds % x, want % x", got, want)
+	}
+	wantFiles := []string{"src/main.S", "src/config.inc", "lib/util.inc", "src/sub/deep.inc", "src/sub/leaf.inc"}
+	if !slices.Equal(program.Files, wantFiles) {
+		t.Errorf("read %v, want %v", program.Files, wantFiles)
+	}
+}
+
+func TestIncbinBounds(t *testing.T) {
+	tests := []struct {
+		args string
+		want []byte // nil when the range is past the end of the file
+	}{
+		{args: "", want: []byte{1, 2, 3, 4}},
+		{args: ", 2", want: []byte{3, 4}},
+		{args: ", 1, 2", want: []byte{2, 3}},
+		{args: ", 4", want: []byte{}},
+		{args: ", 4, 0", want: []byte{}},
+		{args: ", 5"},
+		{args: ", 2, 3"},
+		{args: ", 0x10000, 1"},
+		{args: ", 0x12345678"},
+		{args: ", 1, 0x12345678"},
+	}
+	for _, tt := range tests {
+		_, program, err := assemble(t, avrassembler.Options{}, fstest.MapFS{
+			"main.S":   {Data: []byte(`.incbin "data.bin"` + tt.args + "\n")},
+			"data.bin": {Data: []byte{1, 2, 3, 4}},
+		})
+		if tt.want == nil {
+			if codes := errorCodes(err); !slices.Equal(codes, []string{avrassembler.CodeValueRange}) {
+				t.Errorf(".incbin%s failed with %v, want %s", tt.args, codes, avrassembler.CodeValueRange)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf(".incbin%s failed: %v", tt.args, err)
+			continue
+		}
+		if got := program.Flash.Read(0, uint32(len(tt.want))); program.Flash.Len() < len(tt.want) || !bytes.Equal(got, tt.want) {
+			t.Errorf(".incbin%s placed % x, want % x", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package avrassembler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Motorola S-record image. The narrowest address width that holds the whole
+// image is used: S1/S9 up to 64 KB, S2/S8 up to 16 MB and S3/S7 beyond that
+func toSRecord(image *MemoryImage, recordLength int, header string, entry *uint32) (string, error) {
+	if recordLength < 1 || recordLength > 250 {
+		return "", fmt.Errorf("s-record length %d must be between 1 and 250", recordLength)
+	}
+	_, high := image.Bounds()
+	if entry != nil {
+		// high is one past the last byte, the entry itself must fit too
+		high = max(high, *entry+1)
+	}
+	dataType, endType, addressBytes := byte('1'), byte('9'), 2
+	if high > 0x10000 {
+		dataType, endType, addressBytes = '2', '8', 3
+	}
+	if high > 0x1000000 {
+		dataType, endType, addressBytes = '3', '7', 4
+	}
+
+	srec := sRecord('0', 0, 2, []byte(header))
+	count := 0
+	for _, segment := range image.Segments() {
+		addr := segment.Address
+		data := segment.Data
+		for len(data) > 0 {
+			length := recordLength - int(addr%uint32(recordLength))
+			if length > len(data) {
+				length = len(data)
+			}
+			srec += sRecord(dataType, addr, addressBytes, data[:length])
+			count++
+			addr += uint32(length)
+			data = data[length:]
+		}
+	}
+	if count <= 0xffff {
+		srec += sRecord('5', uint32(count), 2, nil)
+	}
+	start := uint32(0)
+	if entry != nil {
+		start = *entry
+	}
+	srec += sRecord(endType, start, addressBytes, nil)
+	return srec, nil
+}
+
+func sRecord(recordType byte, addr uint32, addressBytes int, data []byte) string {
+	record := []byte{byte(addressBytes + len(data) + 1)}
+	for i := addressBytes - 1; i >= 0; i-- {
+		record = append(record, byte(addr>>(8*i)))
+	}
+	record = append(record, data...)
+	checksum := byte(0)
+	for _, b := range record {
+		checksum += b
+	}
+	return "S" + string(recordType) + strings.ToUpper(hex.EncodeToString(record)) + fmt.Sprintf("%02X", ^checksum) + "\n"
+}
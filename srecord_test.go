@@ -0,0 +1,29 @@
+package avrassembler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSRecordEntryWidth(t *testing.T) {
+	image := NewMemoryImage()
+	image.WriteWords(0, []uint16{0x0000})
+	tests := []struct {
+		entry uint32
+		want  string // Termination record
+	}{
+		{entry: 0x0000, want: "S9030000FC"},
+		{entry: 0xffff, want: "S903FFFFFE"},
+		{entry: 0x10000, want: "S804010000FA"},
+	}
+	for _, tt := range tests {
+		srec, err := toSRecord(image, 16, "", &tt.entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Fields(srec)
+		if last := lines[len(lines)-1]; last != tt.want {
+			t.Errorf("entry 0x%x ends with %s, want %s", tt.entry, last, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package avrassembler
+
+import (
+	"slices"
+	"strings"
+)
+
+// Edit distance between two names ignoring case, a swap of two neighbouring
+// letters counts as one edit like in optimal string alignment
+func editDistance(x string, y string) int {
+	x, y = strings.ToLower(x), strings.ToLower(y)
+	// Three rows of the distance table are enough for the swap lookback
+	before, previous, current := make([]int, len(y)+1), make([]int, len(y)+1), make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				current[j] = min(current[j], before[j-2]+1)
+			}
+		}
+		before, previous, current = previous, current, before
+	}
+	return previous[len(y)]
+}
+
+// Candidate closest to name, empty when none is within a third of its length.
+// Ties go to the longest common prefix, typos tend to be at the end, and then
+// to the alphabetically first candidate so suggestions are stable
+func closestMatch(name string, candidates []string) string {
+	best, bestDistance, bestPrefix := "", max(1, (len(name)+2)/3)+1, 0
+	slices.Sort(candidates)
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, name) {
+			continue
+		}
+		distance, prefix := editDistance(name, candidate), commonPrefix(name, candidate)
+		if distance < bestDistance || distance == bestDistance && prefix > bestPrefix {
+			best, bestDistance, bestPrefix = candidate, distance, prefix
+		}
+	}
+	return best
+}
+
+// Length of the prefix two names share ignoring case
+func commonPrefix(x string, y string) int {
+	n := 0
+	for n < len(x) && n < len(y) && strings.EqualFold(x[n:n+1], y[n:n+1]) {
+		n++
+	}
+	return n
+}
+
+// Closest known name for an undefined label, constant or symbol
+func (a *Assembler) suggestSymbol(name string, kind string) string {
+	candidates := []string{}
+	if kind != "variable" {
+		candidates = append(candidates, a.visibleLabels()...)
+	}
+	if kind != "label" {
+		for constant := range a.VariableMapping {
+			candidates = append(candidates, constant)
+		}
+		// I/O names of the target, the definition file may be missing
+		if a.TargetDevice != nil {
+			for register := range a.TargetDevice.IORegisters {
+				if !slices.Contains(candidates, register) {
+					candidates = append(candidates, register)
+				}
+			}
+		}
+	}
+	return closestMatch(name, candidates)
+}
+
+// Closest instruction or macro for an unknown mnemonic
+func (a *Assembler) suggestMnemonic(mnemonic string) string {
+	// Known instructions the assembler can't encode yet are not unknown
+	if _, ok := InstructionSet[strings.ToUpper(mnemonic)]; ok {
+		return ""
+	}
+	candidates := []string{}
+	for name := range InstructionParse {
+		candidates = append(candidates, name)
+	}
+	for name := range InstructionSet {
+		// Second words of 32 bit instructions start with _
+		if !strings.HasPrefix(name, "_") && !slices.Contains(candidates, name) {
+			candidates = append(candidates, name)
+		}
+	}
+	for name := range a.RawMacroSections {
+		candidates = append(candidates, name)
+	}
+	return closestMatch(mnemonic, candidates)
+}
@@ -0,0 +1,33 @@
+package avrassembler_test
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+func TestSuggestDeviceIORegister(t *testing.T) {
+	tests := []struct {
+		device string
+		source string
+		want   string
+	}{
+		{device: "atmega328p", source: "out PORTBB, r16\n", want: "PORTB"},
+		{device: "atmega328p", source: "lds r16, UDR00\n", want: "UDR0"},
+		{device: "attiny85", source: "in r16, PNB\n", want: "PINB"},
+		{device: "", source: "out PORTBB, r16\n", want: ""},
+	}
+	for _, tt := range tests {
+		_, _, err := assemble(t, avrassembler.Options{Device: tt.device}, fstest.MapFS{"main.S": {Data: []byte(tt.source)}})
+		var list avrassembler.ErrorList
+		if !errors.As(err, &list) || len(list) != 1 {
+			t.Errorf("%q on %q returned %v, want one undefined symbol error", tt.source, tt.device, err)
+			continue
+		}
+		if list[0].Suggestion != tt.want {
+			t.Errorf("%q on %q suggested %q, want %q", tt.source, tt.device, list[0].Suggestion, tt.want)
+		}
+	}
+}
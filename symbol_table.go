@@ -6,34 +6,68 @@ import (
 	simplelog "github.com/ReidRise/simplelogger"
 )
 
+// Memory space a data blob or label belongs to
+type Segment int
+
+const (
+	CodeSegment   Segment = iota // Flash
+	DataSegment                  // SRAM, space can only be reserved
+	EEPROMSegment                // EEPROM
+)
+
+// Directive and ELF section names, indexed by Segment
+var SegmentNames = []string{".cseg", ".dseg", ".eseg"}
+var SectionNames = []string{".text", ".data", ".eeprom"}
+
 // Data to be loaded to a memory location
 type DataBlob struct {
 	Data    []byte
-	Address uint16
+	Address uint32
+	Segment Segment
+	File    string // Source file that placed the data
+	Line    int
+}
+
+// Label in the data or EEPROM segment, addressed in bytes
+type DataLabel struct {
+	Segment Segment
+	Address uint32
 }
 
 // Format for laying out instructions in memory at address
 type AssemblySection struct {
-	Address  uint16
+	Address  uint32
 	Assembly []Instruction
+	File     string // Source file the section was parsed from
 }
 
-// Instruction Sections
-var RawAssemblySections = []AssemblySection{}
-var RawMacroSections = map[string][]Instruction{}
-
-// Labels in Memory
-var LabelMap = map[string]uint16{}
+// File and line a symbol was defined on
+type SourceLocation struct {
+	File string
+	Line int
+}
 
-// Data blobs (strings for now) in memory
-var DbSections = []DataBlob{}
+// Source line with the memory it occupies, in the order it was assembled
+type ListingLine struct {
+	File    string
+	Line    int
+	Depth   int // Include and macro nesting, used for indentation
+	Text    string
+	Segment Segment
+	Address uint32 // Byte address in Segment
+	Size    uint32 // Bytes placed by the line
+	Placed  bool   // False for lines skipped by .if and macro bodies
+}
 
-// Variable Symbols to uint mapping
-var VariableMapping = map[string]uint16{}
+type importFrame struct {
+	Path string // Absolute path used for comparison
+	Name string // Path as written, used in messages
+	Line int    // Line of the .include being followed
+}
 
-func DumpLabelMap() {
+func (a *Assembler) DumpLabelMap() {
 	simplelog.Trace("Label Map:")
-	for key, value := range LabelMap {
+	for key, value := range a.LabelMap {
 		simplelog.Trace(fmt.Sprintf("\t%s @ 0x%04x", key, value))
 	}
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	avrassembler "avrassembler"
 
@@ -12,9 +15,36 @@ import (
 
 // CmdArgs holds input and output file names
 type cmdArgs struct {
-	InputFile  string
-	OutputFile string
-	LogLevel   string
+	InputFile    string
+	OutputFile   string
+	LogLevel     string
+	IncludePaths []string
+	IncludeRoot  string
+	EntryPoint   string
+	RecordLength int
+	Format       string
+	Device       string
+	BinaryBase   uint32
+	PadToFlash   bool
+	ListingFile  string
+	MapFile      string
+	EEPROMFile   string
+	Defines      map[string]int64
+	MaxErrors    int
+	DiagFormat   string
+	Warnings     []string
+}
+
+// stringList collects a flag that may be given several times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 var logLevelMap = map[string]simplelog.Level{
@@ -28,10 +58,40 @@ var logLevelMap = map[string]simplelog.Level{
 // ParseCmdArgs parses command-line arguments for input and output files
 func parseCmdArgs() (*cmdArgs, error) {
 	input := flag.String("i", "", "Input assembly file (.S)")
-	output := flag.String("o", "output.hex", "Output binary file (.hex)")
+	output := flag.String("o", "", "Output file (default output.<format>)")
+	format := flag.String("f", "hex", "Output format: hex, srec, bin or elf")
+	device := flag.String("d", "", "Target device, for example atmega328p")
 	loglevel := flag.String("l", "info", "Log level for assembler")
+	includePaths := stringList{}
+	flag.Var(&includePaths, "I", "Directory searched for .include files (repeatable)")
+	defines := stringList{}
+	flag.Var(&defines, "D", "Define a constant as NAME=VALUE, like .equ (repeatable)")
+	includeRoot := flag.String("include-root", "", "Directory holding device definition files (m328Pdef.inc)")
+	entryPoint := flag.String("entry", "", "Label or byte address written as the hex start address record")
+	recordLength := flag.Int("hex-record-length", 16, "Data bytes per Intel HEX or S-record line (16 or 32)")
+	binaryBase := flag.String("base", "0", "First address written to bin output")
+	padTo := flag.Bool("pad-to", false, "Pad bin output with 0xFF to the full flash size of the device")
+	listing := flag.String("lst", "", "Write a listing with addresses, encoded words and source to this file")
+	mapFile := flag.String("map", "", "Write section placement, symbols and memory usage to this file")
+	eepromFile := flag.String("eep", "", "EEPROM Intel HEX file (default output name with .eep)")
+	maxErrors := flag.Int("max-errors", 20, "Stop after this many errors, 0 to report every error")
+	diagFormat := flag.String("diagnostics-format", "text", "Errors and warnings as text in the log, or json or sarif on stdout")
 
-	flag.Parse()
+	flag.Usage = func() {
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "  -W<warning>, -Wno-<warning>\n    \tSwitch a warning on or off, -Wall for every warning and -Werror to fail on warnings\n    \tWarnings: %s\n", strings.Join(warningNames(), ", "))
+	}
+
+	// The flag package can't parse gcc style -Wname flags, take them out first
+	warnings, rest := []string{}, []string{}
+	for _, arg := range os.Args[1:] {
+		if name, ok := strings.CutPrefix(arg, "-W"); ok && name != "" {
+			warnings = append(warnings, name)
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	flag.CommandLine.Parse(rest)
 
 	if *input == "" {
 		return nil, fmt.Errorf("input file must be specified with -i")
@@ -42,10 +102,51 @@ func parseCmdArgs() (*cmdArgs, error) {
 		return nil, fmt.Errorf("input file does not exist: %s", *input)
 	}
 
+	if *diagFormat != "text" && *diagFormat != "json" && *diagFormat != "sarif" {
+		return nil, fmt.Errorf("unknown diagnostics format %s, use text, json or sarif", *diagFormat)
+	}
+
+	base, err := strconv.ParseUint(*binaryBase, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base address %s", *binaryBase)
+	}
+
+	defineValues := map[string]int64{}
+	for _, define := range defines {
+		name, value, found := strings.Cut(define, "=")
+		if !found {
+			value = "1"
+		}
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for define %s", define)
+		}
+		defineValues[name] = v
+	}
+
+	if *output == "" {
+		*output = "output." + *format
+	}
+
 	return &cmdArgs{
-		InputFile:  *input,
-		OutputFile: *output,
-		LogLevel:   *loglevel,
+		InputFile:    *input,
+		OutputFile:   *output,
+		LogLevel:     *loglevel,
+		IncludePaths: includePaths,
+		IncludeRoot:  *includeRoot,
+		EntryPoint:   *entryPoint,
+		RecordLength: *recordLength,
+		Format:       *format,
+		Device:       *device,
+		BinaryBase:   uint32(base),
+		PadToFlash:   *padTo,
+		ListingFile:  *listing,
+		MapFile:      *mapFile,
+		EEPROMFile:   *eepromFile,
+		Defines:      defineValues,
+		MaxErrors:    *maxErrors,
+		DiagFormat:   *diagFormat,
+		Warnings:     warnings,
 	}, nil
 }
 
@@ -61,20 +162,92 @@ func main() {
 		simplelog.Warn(fmt.Sprintf("log level %s does not exist defaulting to info", args.LogLevel))
 		level = simplelog.InfoLevel
 	}
+	// The JSON or SARIF document is the only output on stdout
+	if args.DiagFormat != "text" {
+		level = simplelog.ErrorLevel + 1
+	}
 
 	avrassembler.SetLogLevel(level)
-
-	avrassembler.ParseFile(args.InputFile, 0x0000)
+	asm, err := avrassembler.NewAssembler(avrassembler.Options{
+		Device:       args.Device,
+		IncludePaths: args.IncludePaths,
+		IncludeRoot:  args.IncludeRoot,
+		Defines:      args.Defines,
+		MaxErrors:    args.MaxErrors,
+		Warnings:     args.Warnings,
+	})
 	if err != nil {
 		simplelog.Error(err.Error())
-		avrassembler.DumpLabelMap()
+		os.Exit(1)
+	}
+	asm.EntryPoint = args.EntryPoint
+	asm.HexRecordLength = args.RecordLength
+	asm.OutputFormat = args.Format
+	asm.BinaryBase = args.BinaryBase
+	asm.PadToFlash = args.PadToFlash
+	asm.ListingFile = args.ListingFile
+	asm.MapFile = args.MapFile
+	asm.EEPROMFile = args.EEPROMFile
+
+	asm.AddFile(args.InputFile)
+	_, err = asm.Assemble()
+	if args.DiagFormat != "text" {
+		if err == nil {
+			err = asm.WriteToFile(args.OutputFile)
+		}
+		writeDiagnostics(args.DiagFormat, asm.Diagnostics())
+		var list avrassembler.ErrorList
+		if err != nil && !errors.As(err, &list) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
+		// Every error was already logged with its source line as it was found
+		var list avrassembler.ErrorList
+		if errors.As(err, &list) {
+			plural := "s"
+			if len(list) == 1 {
+				plural = ""
+			}
+			simplelog.Error(fmt.Sprintf("assembly failed with %d error%s", len(list), plural))
+		} else {
+			simplelog.Error(err.Error())
+		}
+		asm.DumpLabelMap()
 		os.Exit(1)
 	}
 
-	err = avrassembler.WriteToFile(args.OutputFile)
+	err = asm.WriteToFile(args.OutputFile)
 	if err != nil {
 		simplelog.Error(err.Error())
-		avrassembler.DumpLabelMap()
+		asm.DumpLabelMap()
+		os.Exit(1)
+	}
+}
+
+func warningNames() []string {
+	names := []string{}
+	for _, category := range avrassembler.WarningCategories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+// Write diagnostics to stdout as json or sarif
+func writeDiagnostics(format string, diagnostics []avrassembler.Diagnostic) {
+	var err error
+	switch format {
+	case "json":
+		err = avrassembler.WriteDiagnosticsJSON(os.Stdout, diagnostics)
+	case "sarif":
+		err = avrassembler.WriteDiagnosticsSARIF(os.Stdout, diagnostics)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
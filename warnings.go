@@ -0,0 +1,181 @@
+package avrassembler
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Named group of warnings, switched with -W<name> and -Wno-<name>, a .pragma
+// or a nolint comment
+type WarningCategory struct {
+	Name    string
+	Code    string
+	Enabled bool // Reported unless switched off
+}
+
+// Every warning category, unused-label is off unless asked for
+var WarningCategories = []WarningCategory{
+	{Name: "warning-directive", Code: CodeWarningDirective, Enabled: true},
+	{Name: "redefined", Code: CodeRedefined, Enabled: true},
+	{Name: "unused-label", Code: CodeUnusedLabel, Enabled: false},
+	{Name: "shadow", Code: CodeShadowedName, Enabled: true},
+	{Name: "odd-data", Code: CodeOddData, Enabled: true},
+	{Name: "unreachable-code", Code: CodeUnreachable, Enabled: true},
+	{Name: "unknown-pragma", Code: CodeUnknownPragma, Enabled: true},
+	{Name: "fuse-hazard", Code: CodeFuseHazard, Enabled: true},
+	{Name: "fuse-changed", Code: CodeFuseChanged, Enabled: true},
+	{Name: "repeated-include", Code: CodeRepeatedInclude, Enabled: true},
+}
+
+// .pragma warning enable or disable, in effect until the end of its file
+type warningPragma struct {
+	File     string
+	Line     int
+	Category string // Category name or all
+	Enabled  bool
+}
+
+// ; nolint or ; nolint:unused-label,shadow at the end of a line
+var nolintPattern = regexp.MustCompile(`\bnolint\b(?::([\w,-]+))?`)
+
+// Category a warning code belongs to, nil for codes that are not warnings
+func warningCategory(code string) *WarningCategory {
+	index := slices.IndexFunc(WarningCategories, func(c WarningCategory) bool { return c.Code == code })
+	if index < 0 {
+		return nil
+	}
+	return &WarningCategories[index]
+}
+
+func warningCategoryNames() []string {
+	names := []string{}
+	for _, category := range WarningCategories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+func isWarningCategory(name string) bool {
+	return slices.ContainsFunc(WarningCategories, func(c WarningCategory) bool { return c.Name == name })
+}
+
+// Apply a warning flag as given to -W: a category to switch it on, no-<category>
+// to switch it off, all for every category and error or no-error for -Werror
+func (a *Assembler) SetWarning(flag string) error {
+	name, enabled := strings.CutPrefix(flag, "no-")
+	enabled = !enabled
+	switch {
+	case name == "error":
+		a.WarningsAsErrors = enabled
+	case name == "all":
+		for _, category := range WarningCategories {
+			a.Warnings[category.Name] = enabled
+		}
+	case isWarningCategory(name):
+		a.Warnings[name] = enabled
+	default:
+		return fmt.Errorf("unknown warning %s, categories are %s", name, strings.Join(warningCategoryNames(), ", "))
+	}
+	return nil
+}
+
+// Record a .pragma warning enable|disable category[,category] line
+func (a *Assembler) warningPragma(args []string, fn string, line int) error {
+	if len(args) < 2 || (args[0] != "enable" && args[0] != "disable") {
+		return syntaxError("expected .pragma warning enable or disable followed by categories")
+	}
+	for _, name := range args[1:] {
+		if name != "all" && !isWarningCategory(name) {
+			return syntaxError("unknown warning category %s, categories are %s", name, strings.Join(warningCategoryNames(), ", "))
+		}
+		a.warningPragmas = append(a.warningPragmas, warningPragma{File: fn, Line: line, Category: name, Enabled: args[0] == "enable"})
+	}
+	return nil
+}
+
+// Handle a .pragma other than once
+func (a *Assembler) applyPragma(args string, fn string, line int) error {
+	fields := strings.FieldsFunc(args, func(r rune) bool { return r == ' ' || r == ',' })
+	if len(fields) > 0 && strings.ToLower(fields[0]) == "warning" {
+		return a.warningPragma(fields[1:], fn, line)
+	}
+	a.report(SeverityWarning, CodeUnknownPragma, fn, line, fmt.Sprintf("ignoring unknown pragma %s", args))
+	return nil
+}
+
+// Whether a warning of category on a source line should be reported, from the
+// flags, the .pragma lines before it and a nolint comment on the line itself
+func (a *Assembler) warningEnabled(category *WarningCategory, fn string, line int) bool {
+	enabled, ok := a.Warnings[category.Name]
+	if !ok {
+		enabled = category.Enabled
+	}
+	for _, pragma := range a.warningPragmas {
+		if pragma.File == fn && pragma.Line <= line && (pragma.Category == category.Name || pragma.Category == "all") {
+			enabled = pragma.Enabled
+		}
+	}
+	if !enabled || line == 0 {
+		return enabled
+	}
+	text := a.sourceText(fn, line)
+	match := nolintPattern.FindStringSubmatch(text[len(stripComment(text)):])
+	if match == nil {
+		return true
+	}
+	return match[1] != "" && !slices.Contains(strings.Split(match[1], ","), category.Name)
+}
+
+// Warn about a label named like a register, an instruction or a constant,
+// operands and expressions may read it as the other one
+func (a *Assembler) checkLabelName(name string, at Span) {
+	_, isInstruction := InstructionSet[strings.ToUpper(name)]
+	_, hasParser := InstructionParse[strings.ToUpper(name)]
+	if isRegisterName(name) {
+		a.reportAt(SeverityWarning, CodeShadowedName, at, fmt.Sprintf("label %s has the name of a register", name))
+	} else if isInstruction || hasParser {
+		a.reportAt(SeverityWarning, CodeShadowedName, at, fmt.Sprintf("label %s has the name of the %s instruction", name, strings.ToUpper(name)))
+	}
+	if _, ok := a.VariableMapping[name]; ok {
+		related := []RelatedLocation{}
+		// I/O names from a device definition file have a location, Options.Defines don't
+		if defined, ok := a.SymbolDefinitions[name]; ok {
+			related = append(related, RelatedLocation{Message: fmt.Sprintf("constant %s defined here", name), Source: defined})
+		}
+		a.reportAt(SeverityWarning, CodeShadowedName, at, fmt.Sprintf("label %s has the name of a constant, expressions will use the constant", name), related...)
+	} else if a.TargetDevice != nil {
+		// Without the device definition file the name is still taken on the target
+		if _, ok := a.TargetDevice.IORegisters[strings.ToUpper(name)]; ok {
+			a.reportAt(SeverityWarning, CodeShadowedName, at, fmt.Sprintf("label %s has the name of the %s I/O register on the %s", name, strings.ToUpper(name), a.TargetDevice.Name))
+		}
+	}
+}
+
+// Warn about code and data labels nothing refers to
+func (a *Assembler) checkUnusedLabels() {
+	labels := []string{}
+	for name := range a.LabelMap {
+		labels = append(labels, name)
+	}
+	for name := range a.DataLabelMap {
+		labels = append(labels, name)
+	}
+	// Report in source order
+	slices.SortFunc(labels, func(x, y string) int {
+		sx, sy := a.SymbolDefinitions[x], a.SymbolDefinitions[y]
+		if sx.File != sy.File {
+			return strings.Compare(sx.File, sy.File)
+		}
+		return sx.Line - sy.Line
+	})
+	for _, key := range labels {
+		file, name := splitLabelKey(key)
+		// .global labels are there for other files
+		if file == "" || a.referencedSymbols[key] || name == a.EntryPoint {
+			continue
+		}
+		a.reportAt(SeverityWarning, CodeUnusedLabel, a.labelSpans[key], fmt.Sprintf("label %s is never used", name))
+	}
+}
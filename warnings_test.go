@@ -0,0 +1,65 @@
+package avrassembler_test
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	avrassembler "avrassembler"
+)
+
+// Messages of the warnings with code in a Program
+func warnings(program *avrassembler.Program, code string) []string {
+	messages := []string{}
+	for _, d := range program.Diagnostics {
+		if d.Code == code {
+			messages = append(messages, d.Message)
+		}
+	}
+	return messages
+}
+
+func TestDeviceIORegisterShadow(t *testing.T) {
+	tests := []struct {
+		device string
+		source string
+		want   []string
+	}{
+		{device: "atmega328p", source: "PORTB: rjmp PORTB\n", want: []string{"label PORTB has the name of the PORTB I/O register on the ATmega328P"}},
+		{device: "atmega328p", source: "udr0: rjmp udr0\n", want: []string{"label udr0 has the name of the UDR0 I/O register on the ATmega328P"}},
+		// No PORTA on the ATmega328P
+		{device: "atmega328p", source: "PORTA: rjmp PORTA\n", want: []string{}},
+		{device: "atmega8515", source: "PORTA: rjmp PORTA\n", want: []string{"label PORTA has the name of the PORTA I/O register on the ATmega8515"}},
+		{device: "", source: "PORTB: rjmp PORTB\n", want: []string{}},
+	}
+	for _, tt := range tests {
+		program := mustAssemble(t, avrassembler.Options{Device: tt.device}, tt.source)
+		if got := warnings(program, avrassembler.CodeShadowedName); !slices.Equal(got, tt.want) {
+			t.Errorf("%q on %q warned %q, want %q", tt.source, tt.device, got, tt.want)
+		}
+	}
+}
+
+func TestLabelDiagnosticSpans(t *testing.T) {
+	asm, _, _ := assemble(t, avrassembler.Options{Device: "atmega328p", Warnings: []string{"unused-label"}}, fstest.MapFS{
+		"main.S": {Data: []byte(".extern  foo, bar\n  PORTB: nop\nr5: rjmp r5\n")},
+	})
+	type span struct {
+		code              string
+		line, column, end int
+	}
+	got := []span{}
+	for _, d := range asm.Diagnostics() {
+		got = append(got, span{code: d.Code, line: d.Source.Line, column: d.Column, end: d.EndColumn})
+	}
+	want := []span{
+		{code: avrassembler.CodeShadowedName, line: 2, column: 3, end: 9},
+		{code: avrassembler.CodeShadowedName, line: 3, column: 1, end: 4},
+		{code: avrassembler.CodeUndefinedSymbol, line: 1, column: 10, end: 13},
+		{code: avrassembler.CodeUndefinedSymbol, line: 1, column: 15, end: 18},
+		{code: avrassembler.CodeUnusedLabel, line: 2, column: 3, end: 9},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got diagnostics %+v, want %+v", got, want)
+	}
+}